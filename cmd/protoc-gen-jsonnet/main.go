@@ -39,7 +39,11 @@ func run() error {
 	if err := proto.Unmarshal(in, req); err != nil {
 		return err
 	}
-	cg := codegen.NewCodeGenerator(codegen.Options{})
+	opts, err := codegen.ParseOptions(req.GetParameter())
+	if err != nil {
+		return err
+	}
+	cg := codegen.NewCodeGenerator(opts)
 	res, err := cg.Generate(req)
 	if err != nil {
 		return err
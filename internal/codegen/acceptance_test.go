@@ -25,9 +25,13 @@ type Test struct {
 }
 
 type Suite struct {
-	VM              string   `json:"vm"`
-	IncludeValidate bool     `json:"includeValidate,omitempty"`
-	ProtoFiles      []string `json:"protoFiles,omitempty"`
+	VM              string            `json:"vm"`
+	IncludeValidate bool              `json:"includeValidate,omitempty"`
+	ProtoFiles      []string          `json:"protoFiles,omitempty"`
+	FilesToGenerate []string          `json:"filesToGenerate,omitempty"`
+	Parameter       string            `json:"parameter,omitempty"`
+	ExtVars         map[string]string `json:"extVars,omitempty"`
+	Reference       *Suite            `json:"reference,omitempty"` // a tree generated before the suite for deps=reference
 }
 
 type testRunner struct {
@@ -79,12 +83,14 @@ type suiteRunner struct {
 	dir    string
 	config Suite
 	genDir string
+	refDir string
 }
 
-func (s *suiteRunner) run() {
-
+// generate generates code for the supplied suite configuration in a subdirectory of the supplied directory and returns
+// the directory name.
+func (s *suiteRunner) generate(config Suite, dir string) string {
 	var includePaths []string
-	if s.config.ProtoFiles == nil {
+	if config.ProtoFiles == nil {
 		files, err := filepath.Glob(fmt.Sprintf("%s/*.proto", s.dir))
 		require.NoError(s.t, err)
 		var protoFiles []string
@@ -98,20 +104,29 @@ func (s *suiteRunner) run() {
 			includePaths = append(includePaths, filepath.Dir(file))
 			protoFiles = append(protoFiles, filepath.Base(file))
 		}
-		s.config.ProtoFiles = protoFiles
+		config.ProtoFiles = protoFiles
 	}
 
-	if s.config.IncludeValidate {
+	if config.IncludeValidate {
 		includePaths = append(includePaths, ".", "..")
 	}
 	req := testutil.Request(s.t, testutil.ProtocConfig{
-		Files:        s.config.ProtoFiles,
-		IncludePaths: includePaths,
-		Parameter:    "",
+		Files:           config.ProtoFiles,
+		FilesToGenerate: config.FilesToGenerate,
+		IncludePaths:    includePaths,
+		Parameter:       config.Parameter,
 	})
-	cg := codegen.NewCodeGenerator(codegen.Options{})
-	generatedDir := testutil.GenerateCode(s.t, cg, req, s.dir)
-	s.genDir = generatedDir
+	opts, err := codegen.ParseOptions(req.GetParameter())
+	require.NoError(s.t, err)
+	return testutil.GenerateCode(s.t, codegen.NewCodeGenerator(opts), req, dir)
+}
+
+func (s *suiteRunner) run() {
+	s.genDir = s.generate(s.config, s.dir)
+	// the reference tree is generated inside the generated directory, which is recreated for every run
+	if s.config.Reference != nil {
+		s.refDir = s.generate(*s.config.Reference, s.genDir)
+	}
 	file := filepath.Join(s.dir, "tests.jsonnet")
 	b, err := os.ReadFile(file)
 	require.NoError(s.t, err)
@@ -135,29 +150,60 @@ func (s *suiteRunner) run() {
 
 func (s *suiteRunner) vm() func(code, name string) (string, error) {
 	jvm := jsonnet.MakeVM()
-	jvm.Importer(&jsonnet.FileImporter{JPaths: []string{s.genDir}})
+	jPaths := []string{s.genDir}
+	if s.refDir != "" {
+		jPaths = append(jPaths, s.refDir)
+	}
+	jvm.Importer(&jsonnet.FileImporter{JPaths: jPaths})
+	for k, v := range s.config.ExtVars {
+		jvm.ExtVar(k, v)
+	}
 	return func(code, name string) (string, error) {
 		return jvm.EvaluateAnonymousSnippet(name, code)
 	}
 }
 
+func loadSuite(t *testing.T, suiteFile string) Suite {
+	b, err := os.ReadFile(suiteFile)
+	require.NoError(t, err)
+	var suite Suite
+	err = json.Unmarshal(b, &suite)
+	require.NoError(t, err)
+	return suite
+}
+
 func TestAcceptance(t *testing.T) {
 	suiteFiles, err := filepath.Glob("testdata/*/suite.json")
 	require.NoError(t, err)
 	for _, suiteFile := range suiteFiles {
 		name := filepath.Base(filepath.Dir(suiteFile))
 		t.Run(name, func(t *testing.T) {
-			b, err := os.ReadFile(suiteFile)
-			require.NoError(t, err)
-			var suite Suite
-			err = json.Unmarshal(b, &suite)
-			require.NoError(t, err)
 			runner := &suiteRunner{
 				t:      t,
 				dir:    filepath.Dir(suiteFile),
-				config: suite,
+				config: loadSuite(t, suiteFile),
 			}
 			runner.run()
 		})
 	}
 }
+
+// TestAcceptanceLayouts runs the suites that cover nested types, multiple packages and dependencies with the layouts
+// that bundle multiple types in a file.
+func TestAcceptanceLayouts(t *testing.T) {
+	for _, layout := range []codegen.Layout{codegen.LayoutFile, codegen.LayoutPackage} {
+		for _, name := range []string{"deps", "filenames", "multipkg", "output", "proto2", "simple"} {
+			t.Run(string(layout)+"/"+name, func(t *testing.T) {
+				dir := filepath.Join("testdata", name)
+				suite := loadSuite(t, filepath.Join(dir, "suite.json"))
+				param := "layout=" + string(layout)
+				if suite.Parameter != "" {
+					param = suite.Parameter + "," + param
+				}
+				suite.Parameter = param
+				runner := &suiteRunner{t: t, dir: dir, config: suite}
+				runner.run()
+			})
+		}
+	}
+}
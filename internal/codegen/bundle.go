@@ -0,0 +1,111 @@
+/*
+   Copyright 2022 Splunk Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package codegen
+
+import (
+	"path"
+	"sort"
+
+	"github.com/splunk/protobuf-jsonnet/internal/model"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// bundleEntry is a type in a bundle, exactly one of the fields is set.
+type bundleEntry struct {
+	Enum    *model.Enum
+	Message *messageJsonnetData
+}
+
+// bundleTemplateData is the data for the bundle template.
+type bundleTemplateData struct {
+	Source    string // the proto file or package of the types
+	Generator string // the import path of the generator library
+	Entries   []bundleEntry
+}
+
+// bundleTemplate is the code gen template for a file with the library objects of multiple types keyed by qualified name.
+var bundleTemplate = templateFor(enumBody + messageBody + `
+// Types of {{.Source}}
+// Definitions generated by protoc-gen-jsonnet. DO NOT EDIT.
+local generator = import '{{.Generator}}';
+
+local types = {
+	{{- range .Entries}}
+	{{- with .Enum}}
+	// Enum type: {{.QualifiedName}}
+	'{{.QualifiedName}}': ({{template "enum" .}}),
+	{{- end}}
+	{{- with .Message}}
+	// Message type: {{.QualifiedName}}
+	'{{.QualifiedName}}': ({{template "message" .}}),
+	{{- end}}
+	{{- end}}
+};
+
+types
+`)
+
+// bundles returns the types of the tree grouped by the path of their library file, for layouts other than LayoutType.
+func (c *CodeGenerator) bundles(t *tree) map[string][]model.Type {
+	ret := map[string][]model.Type{}
+	for _, v := range model.SortedTypes(t.types) {
+		p := c.libraryPath(v)
+		ret[p] = append(ret[p], v)
+	}
+	return ret
+}
+
+// bundlePaths returns the paths of the supplied bundles in order.
+func bundlePaths(bundles map[string][]model.Type) []string {
+	var ret []string
+	for p := range bundles {
+		ret = append(ret, p)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// generateBundle generates the library file for the supplied types, which share the same library path.
+func (c *CodeGenerator) generateBundle(t *tree, libraryPath string, types []model.Type) (*pluginpb.CodeGeneratorResponse_File, error) {
+	data := bundleTemplateData{
+		Source:    "proto file " + types[0].File(),
+		Generator: c.importPath(t, path.Dir(path.Join(pkgPath, libraryPath)), generatorJsonnetFile),
+	}
+	if c.layout() == LayoutPackage {
+		data.Source = "package " + types[0].Package()
+	}
+	for _, v := range types {
+		switch {
+		case v.GetEnum() != nil:
+			data.Entries = append(data.Entries, bundleEntry{Enum: v.GetEnum()})
+		case v.GetMessage() != nil:
+			data.Entries = append(data.Entries, bundleEntry{Message: &messageJsonnetData{
+				Message: v.GetMessage(),
+				ref:     func(n model.Type) string { return "types['" + n.QualifiedName() + "']" },
+			}})
+		}
+	}
+	content, err := generateJsonnet(bundleTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(pkgPath + "/" + libraryPath + ".libsonnet"),
+		Content: proto.String(content),
+	}, nil
+}
@@ -18,7 +18,14 @@ package codegen
 
 import (
 	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
 
+	"github.com/google/go-jsonnet"
 	"github.com/splunk/protobuf-jsonnet/internal/model"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
@@ -26,22 +33,22 @@ import (
 )
 
 const (
-	typesFile              = "types.libsonnet"
-	docIndexFile           = "index.html"
+	defaultTypesFile       = "types.libsonnet"
+	defaultIndexFile       = "index.html"
 	pkgPath                = "pkg"
 	docPath                = "doc"
 	validatorsFile         = pkgPath + "/validators.libsonnet"
+	optionsFile            = pkgPath + "/options.libsonnet"
 	generatorJsonnetFile   = pkgPath + "/generator.libsonnet"
 	constraintsJsonnetFile = pkgPath + "/field-constraints.libsonnet"
 	dispatchJsonnetFile    = pkgPath + "/dispatch.libsonnet"
 	wellKnownJsonnetFile   = pkgPath + "/well-known.libsonnet"
+	regexJsonnetFile       = pkgPath + "/regex.libsonnet"
+	formatsJsonnetFile     = pkgPath + "/formats.libsonnet"
+	timeJsonnetFile        = pkgPath + "/time.libsonnet"
 	stylesFile             = docPath + "/styles.css"
 )
 
-// Options are code generator Options.
-type Options struct {
-}
-
 // CodeGenerator generates the jsonnet code for a set of messages and enums.
 type CodeGenerator struct {
 	Options
@@ -56,44 +63,422 @@ func NewCodeGenerator(opts Options) *CodeGenerator {
 	}
 }
 
+// tree is a self-contained set of generated files rooted at a directory of the output.
+type tree struct {
+	root  string                // the directory under which files are generated relative to the output directory
+	types map[string]model.Type // types generated in this tree keyed by qualified name
+	refs  map[string]model.Type // types generated elsewhere that can be referenced from this tree
+}
+
+// fileName returns the name of the supplied file in the tree.
+func (t *tree) fileName(name string) string {
+	return path.Join(t.root, name)
+}
+
+// depsDir returns the directory for the dependency tree. For DepsEmit this is relative to the output
+// directory and for DepsReference this is relative to a directory on the jsonnet library path.
+func (c *CodeGenerator) depsDir() string {
+	if c.DepsDir == "" && c.depsMode() == DepsEmit {
+		return "deps"
+	}
+	return c.DepsDir
+}
+
+func (c *CodeGenerator) depsMode() DepsMode {
+	if c.Deps == "" {
+		return DepsEmit
+	}
+	return c.Deps
+}
+
+func (c *CodeGenerator) imports() ImportStyle {
+	if c.Imports == "" {
+		return ImportsRelative
+	}
+	return c.Imports
+}
+
+func (c *CodeGenerator) typesFile() string {
+	if c.TypesFile == "" {
+		return defaultTypesFile
+	}
+	return c.TypesFile
+}
+
+func (c *CodeGenerator) indexFile() string {
+	if c.IndexFile == "" {
+		return defaultIndexFile
+	}
+	return c.IndexFile
+}
+
+// importPath returns the path used to import a file of the tree from a file in the supplied directory of the same tree.
+// Both the directory and the file are relative to the root of the tree.
+func (c *CodeGenerator) importPath(t *tree, dir, file string) string {
+	if c.imports() == ImportsRooted {
+		return path.Join(t.root, file)
+	}
+	return relativePath(dir, file)
+}
+
+// relativePath returns the path of a file relative to a directory, where both are clean relative paths.
+func relativePath(dir, file string) string {
+	var dirs, elems []string
+	if dir != "" && dir != "." {
+		dirs = strings.Split(dir, "/")
+	}
+	elems = strings.Split(file, "/")
+	common := 0
+	for common < len(dirs) && common < len(elems)-1 && dirs[common] == elems[common] {
+		common++
+	}
+	var ret []string
+	for range dirs[common:] {
+		ret = append(ret, "..")
+	}
+	return strings.Join(append(ret, elems[common:]...), "/")
+}
+
+func (c *CodeGenerator) layout() Layout {
+	if c.Layout == "" {
+		return LayoutType
+	}
+	return c.Layout
+}
+
+// refPath returns the path to the library file of the supplied dependency type relative to the package directory of
+// the main tree.
+func (c *CodeGenerator) refPath(t model.Type) string {
+	p := path.Join(c.depsDir(), pkgPath, c.libraryPath(t))
+	switch {
+	case c.depsMode() != DepsEmit:
+		return p
+	case c.imports() == ImportsRooted:
+		return path.Join(c.Prefix, p)
+	default:
+		return "../" + p
+	}
+}
+
+// refDocPath returns the path to the documentation of the supplied dependency type relative to the
+// doc directory of the main tree, or the empty string if no documentation is available.
+func (c *CodeGenerator) refDocPath(t model.Type) string {
+	if c.depsMode() != DepsEmit || c.SkipDocs {
+		return ""
+	}
+	return path.Join("..", c.depsDir(), docPath, c.filePathForType(t))
+}
+
+// wellKnownTypes returns the types with a validator in well-known.libsonnet, which takes precedence over generated
+// code. The names are read from the table in that file, so that a type added there is never also emitted as a
+// dependency.
+func wellKnownTypes() (map[string]bool, error) {
+	file := path.Base(wellKnownJsonnetFile)
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.MemoryImporter{Data: map[string]jsonnet.Contents{file: jsonnet.MakeContents(wellKnownJsonnet)}})
+	out, err := vm.EvaluateAnonymousSnippet("well-known-types", fmt.Sprintf("std.objectFields(import '%s')", file))
+	if err != nil {
+		return nil, fmt.Errorf("read well-known types: %w", err)
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(out), &names); err != nil {
+		return nil, fmt.Errorf("read well-known types: %w", err)
+	}
+	ret := map[string]bool{}
+	for _, name := range names {
+		ret[name] = true
+	}
+	return ret, nil
+}
+
+// splitTypes returns the types defined in the supplied files and the remaining types. Extensions declared in the
+// supplied files are left out of the dependency messages they extend, so that dependencies never refer to the main
+// types and are generated as they would be for any other user of the dependency.
+func (c *CodeGenerator) splitTypes(files []string) (main, deps map[string]model.Type) {
+	gen := map[string]bool{}
+	for _, f := range files {
+		gen[f] = true
+	}
+	main, deps = map[string]model.Type{}, map[string]model.Type{}
+	for k, v := range c.TypeMap {
+		switch {
+		case gen[v.File()]:
+			main[k] = v
+		case v.GetMessage() != nil:
+			deps[k] = v.GetMessage().WithoutExtensionsFrom(gen)
+		default:
+			deps[k] = v
+		}
+	}
+	return main, deps
+}
+
+// reachableTypes returns the dependency types that the fields of the main types refer to, directly or through other
+// dependencies, along with the nested types of reachable messages since their libraries refer to them. Well-known
+// types handled by well-known.libsonnet are left out, as are types that are only used in options, like those of
+// validate.proto and descriptor.proto.
+func (c *CodeGenerator) reachableTypes(main, deps map[string]model.Type) (map[string]model.Type, error) {
+	wellKnown, err := wellKnownTypes()
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]model.Type{}
+	var visitFields func(t model.Type)
+	add := func(name string) {
+		dep, ok := deps[name]
+		if !ok || wellKnown[name] || ret[name] != nil {
+			return
+		}
+		ret[name] = dep
+		visitFields(dep)
+	}
+	visitFields = func(t model.Type) {
+		msg := t.GetMessage()
+		if msg == nil {
+			return
+		}
+		for _, m := range msg.NestedMessages() {
+			add(m.QualifiedName())
+		}
+		for _, e := range msg.NestedEnums() {
+			add(e.QualifiedName())
+		}
+		for _, f := range msg.Fields() {
+			add(f.TypeName())
+			add(f.MaskTarget())
+		}
+	}
+	for _, t := range main {
+		visitFields(t)
+	}
+	return ret, nil
+}
+
+// Generate returns the files for the supplied request. Problems are not reported one at a time: all errors found
+// while loading the types and generating code are returned together, located in the proto files where possible.
 func (c *CodeGenerator) Generate(req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
-	c.TypeMap = model.Load(&descriptorpb.FileDescriptorSet{File: req.GetProtoFile()})
+	var errs []error
+	typeMap, err := model.Load(&descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}, model.LoadOptions{
+		SkipValidations:    c.SkipValidations,
+		ValidationsInclude: c.ValidationsInclude,
+		ValidationsExclude: c.ValidationsExclude,
+		FieldMaskTargets:   c.FieldMaskTargets,
+	})
+	c.TypeMap = typeMap
+	errs = append(errs, err, c.checkFieldMaskTargets())
 
-	tlMap := c.TypeLinkMap()
+	// only dependencies used by the main types are emitted or referenced, since a referenced tree generated for the
+	// dependency files does not have the types that are only used in options or handled by well-known.libsonnet either
+	main, deps := c.splitTypes(req.GetFileToGenerate())
+	deps, err = c.reachableTypes(main, deps)
+	errs = append(errs, err)
+	if c.depsMode() == DepsEmit && len(deps) > 0 {
+		errs = append(errs, c.generateTree(&tree{root: path.Join(c.Prefix, c.depsDir()), types: deps}))
+	}
+	errs = append(errs, c.generateTree(&tree{root: c.Prefix, types: main, refs: deps}))
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
 
-	// generate stuff
-	for _, v := range c.TypeMap {
-		var f *pluginpb.CodeGeneratorResponse_File
-		{
-			switch {
-			case v.GetEnum() != nil:
-				f = c.generateEnum(v.GetEnum())
-			case v.GetMessage() != nil:
-				f = c.generateMessage(v.GetMessage())
+	// files are sorted by name so that the response is identical for the same request
+	sort.Slice(c.files, func(i, j int) bool {
+		return c.files[i].GetName() < c.files[j].GetName()
+	})
+	return &pluginpb.CodeGeneratorResponse{
+		SupportedFeatures: proto.Uint64(uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)),
+		File:              c.files,
+	}, nil
+}
+
+// checkFieldMaskTargets returns an error for every field mask target option that does not refer to a singular
+// FieldMask field or whose target is not a message.
+func (c *CodeGenerator) checkFieldMaskTargets() error {
+	var names []string
+	for name := range c.FieldMaskTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		target := c.FieldMaskTargets[name]
+		pos := strings.LastIndex(name, ".")
+		if pos < 0 {
+			errs = append(errs, fmt.Errorf("field_mask_target %s: want qualified field name like pkg.Message.field", name))
+			continue
+		}
+		var field *model.Field
+		if t, ok := c.TypeMap[name[:pos]]; ok && t.GetMessage() != nil {
+			for _, f := range t.GetMessage().Fields() {
+				if f.Name() == name[pos+1:] {
+					field = f
+				}
 			}
-			c.files = append(c.files, f)
 		}
-		{
-			switch {
-			case v.GetEnum() != nil:
-				f = c.generateEnumDocs(v.GetEnum(), tlMap)
-			case v.GetMessage() != nil:
-				f = c.generateMessageDocs(v.GetMessage(), tlMap)
+		if field == nil {
+			errs = append(errs, fmt.Errorf("field_mask_target %s: field not found", name))
+			continue
+		}
+		fieldError := func(format string, args ...interface{}) {
+			errs = append(errs, &model.Error{
+				Location: field.Location(),
+				Element:  name,
+				Err:      fmt.Errorf("field_mask_target: "+format, args...),
+			})
+		}
+		if field.TypeName() != "google.protobuf.FieldMask" || field.ContainerType() != model.ContainerTypeNone {
+			fieldError("want singular google.protobuf.FieldMask field, got %s", field.TypeName())
+		}
+		if t, ok := c.TypeMap[target]; !ok || t.GetMessage() == nil {
+			fieldError("target message %s not found", target)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkFileNames returns an error for every type in the tree whose files would overwrite the files of another type,
+// like HTTPConfig and Http_Config or a nested Foo.Bar and a top-level FooBar when file names are kebab-cased. Names are
+// compared ignoring case, since HTTPConfig and HttpConfig are the same file on case-insensitive file systems even when
+// file names are verbatim. Types are visited in order of their qualified names, so the first type using a file name is
+// the one that is not reported. Nothing is checked when no files are generated for single types.
+func (c *CodeGenerator) checkFileNames(t *tree) error {
+	if c.layout() != LayoutType && c.SkipDocs {
+		return nil
+	}
+	owners := map[string]model.Type{}
+	var errs []error
+	for _, v := range model.SortedTypes(t.types) {
+		name := c.filePathForType(v)
+		key := strings.ToLower(name)
+		if owner, ok := owners[key]; ok {
+			msg := fmt.Sprintf("file name %s is already used by %s", name, owner.QualifiedName())
+			if ownerName := c.filePathForType(owner); ownerName != name {
+				msg = fmt.Sprintf("file name %s only differs in case from %s used by %s, which is the same file on "+
+					"case-insensitive file systems", name, ownerName, owner.QualifiedName())
 			}
-			if f != nil {
-				c.files = append(c.files, f)
+			fix := "rename the type"
+			if c.FileNames != FileNamesVerbatim && !strings.EqualFold(v.NestedName(), owner.NestedName()) {
+				fix += fmt.Sprintf(" or set file_names=%s", FileNamesVerbatim)
 			}
+			errs = append(errs, &model.Error{
+				Location: v.Location(),
+				Element:  v.QualifiedName(),
+				Err:      fmt.Errorf("%s, %s", msg, fix),
+			})
+			continue
 		}
+		owners[key] = v
 	}
+	return errors.Join(errs...)
+}
 
-	c.files = append(c.files, c.generateValidator())
-	c.files = append(c.files, c.generateTypes())
-	c.files = append(c.files, c.generateDocIndex(tlMap))
+// checkBundleNames returns an error for proto files of the same package whose bundles would have the same name with
+// LayoutFile.
+func (c *CodeGenerator) checkBundleNames(t *tree) error {
+	if c.layout() != LayoutFile {
+		return nil
+	}
+	bundles := c.bundles(t)
+	var errs []error
+	for _, p := range bundlePaths(bundles) {
+		types := bundles[p]
+		for _, v := range types[1:] {
+			if v.File() != types[0].File() {
+				errs = append(errs, fmt.Errorf("proto files %s and %s of package %s both use the bundle name %s, rename one of the files or set layout=%s",
+					types[0].File(), v.File(), v.Package(), p, LayoutPackage))
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	c.files = append(c.files, c.staticFiles()...)
-	return &pluginpb.CodeGeneratorResponse{
-		File: c.files,
-	}, nil
+// generateTree generates all files for the supplied tree, returning the errors for all types that could not be
+// generated.
+func (c *CodeGenerator) generateTree(t *tree) error {
+	if err := errors.Join(c.checkFileNames(t), c.checkBundleNames(t)); err != nil {
+		return err
+	}
+	tlMap := c.TypeLinkMap(t)
+	var errs []error
+
+	// generate stuff
+	for _, v := range model.SortedTypes(t.types) {
+		if err := c.generateType(t, v, tlMap); err != nil {
+			errs = append(errs, &model.Error{Location: v.Location(), Element: v.QualifiedName(), Err: err})
+		}
+	}
+
+	if c.layout() != LayoutType {
+		bundles := c.bundles(t)
+		for _, p := range bundlePaths(bundles) {
+			f, err := c.generateBundle(t, p, bundles[p])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", p, err))
+				continue
+			}
+			c.addFile(t, f)
+		}
+	}
+
+	generators := []func() (*pluginpb.CodeGeneratorResponse_File, error){
+		func() (*pluginpb.CodeGeneratorResponse_File, error) { return c.generateValidator(t) },
+		c.generateOptions,
+		func() (*pluginpb.CodeGeneratorResponse_File, error) { return c.generateTypes(t) },
+	}
+	if !c.SkipDocs {
+		generators = append(generators, func() (*pluginpb.CodeGeneratorResponse_File, error) { return c.generateDocIndex(tlMap) })
+	}
+	for _, gen := range generators {
+		f, err := gen()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.addFile(t, f)
+	}
+
+	for _, f := range c.staticFiles() {
+		c.addFile(t, f)
+	}
+	return errors.Join(errs...)
+}
+
+// generateType generates the documentation file for a single type and, with LayoutType, its library file.
+func (c *CodeGenerator) generateType(t *tree, v model.Type, tlMap *typeLinkMap) error {
+	var f *pluginpb.CodeGeneratorResponse_File
+	var err error
+	if c.layout() == LayoutType {
+		switch {
+		case v.GetEnum() != nil:
+			f, err = c.generateEnum(v.GetEnum())
+		case v.GetMessage() != nil:
+			f, err = c.generateMessage(t, v.GetMessage())
+		}
+		if err != nil {
+			return err
+		}
+		c.addFile(t, f)
+	}
+	if c.SkipDocs {
+		return nil
+	}
+	switch {
+	case v.GetEnum() != nil:
+		f, err = c.generateEnumDocs(t, v.GetEnum(), tlMap)
+	case v.GetMessage() != nil:
+		f, err = c.generateMessageDocs(t, v.GetMessage(), tlMap)
+	}
+	if err != nil {
+		return fmt.Errorf("docs: %w", err)
+	}
+	c.addFile(t, f)
+	return nil
+}
+
+// addFile adds the supplied file to the output, placing it under the root of the tree.
+func (c *CodeGenerator) addFile(t *tree, f *pluginpb.CodeGeneratorResponse_File) {
+	f.Name = proto.String(t.fileName(f.GetName()))
+	c.files = append(c.files, f)
 }
 
 type link struct {
@@ -111,10 +496,16 @@ func (tlm *typeLinkMap) Link(name string) *link {
 	return nil
 }
 
-func (c *CodeGenerator) TypeLinkMap() *typeLinkMap {
+// TypeLinkMap returns the documentation links for all types generated in or referenced by the supplied tree.
+func (c *CodeGenerator) TypeLinkMap(t *tree) *typeLinkMap {
 	ret := map[string]string{}
-	for _, v := range c.TypeMap {
-		ret[v.QualifiedName()] = filePathForType(v)
+	for _, v := range t.refs {
+		if p := c.refDocPath(v); p != "" {
+			ret[v.QualifiedName()] = p
+		}
+	}
+	for _, v := range t.types {
+		ret[v.QualifiedName()] = c.filePathForType(v)
 	}
 	return &typeLinkMap{Map: ret}
 }
@@ -134,8 +525,17 @@ var generatorJsonnet string
 //go:embed static/field-constraints.libsonnet
 var constraintsJsonnet string
 
+//go:embed static/regex.libsonnet
+var regexJsonnet string
+
+//go:embed static/formats.libsonnet
+var formatsJsonnet string
+
+//go:embed static/time.libsonnet
+var timeJsonnet string
+
 func (c *CodeGenerator) staticFiles() []*pluginpb.CodeGeneratorResponse_File {
-	return []*pluginpb.CodeGeneratorResponse_File{
+	ret := []*pluginpb.CodeGeneratorResponse_File{
 		{
 			Name:    proto.String(wellKnownJsonnetFile),
 			Content: proto.String(wellKnownJsonnet),
@@ -153,8 +553,23 @@ func (c *CodeGenerator) staticFiles() []*pluginpb.CodeGeneratorResponse_File {
 			Content: proto.String(constraintsJsonnet),
 		},
 		{
+			Name:    proto.String(regexJsonnetFile),
+			Content: proto.String(regexJsonnet),
+		},
+		{
+			Name:    proto.String(formatsJsonnetFile),
+			Content: proto.String(formatsJsonnet),
+		},
+		{
+			Name:    proto.String(timeJsonnetFile),
+			Content: proto.String(timeJsonnet),
+		},
+	}
+	if !c.SkipDocs {
+		ret = append(ret, &pluginpb.CodeGeneratorResponse_File{
 			Name:    proto.String(stylesFile),
 			Content: proto.String(stylesCSS),
-		},
+		})
 	}
+	return ret
 }
@@ -0,0 +1,337 @@
+package codegen_test
+
+import (
+	"flag"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/splunk/protobuf-jsonnet/internal/codegen"
+	"github.com/splunk/protobuf-jsonnet/internal/model"
+	"github.com/splunk/protobuf-jsonnet/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// depsRequest returns a request for the deps test data that only generates code for the main proto file.
+func depsRequest(t *testing.T) *pluginpb.CodeGeneratorRequest {
+	return testutil.Request(t, testutil.ProtocConfig{
+		Files:           []string{"testdata/deps/message.proto", "testdata/deps/lib/lib.proto"},
+		FilesToGenerate: []string{"testdata/deps/message.proto"},
+		IncludePaths:    []string{".", ".."},
+	})
+}
+
+// generatedFiles returns the contents of the files generated for the request keyed by file name.
+func generatedFiles(t *testing.T, req *pluginpb.CodeGeneratorRequest, opts codegen.Options) map[string]string {
+	res, err := codegen.NewCodeGenerator(opts).Generate(req)
+	require.NoError(t, err)
+	ret := map[string]string{}
+	for _, f := range res.GetFile() {
+		ret[f.GetName()] = f.GetContent()
+	}
+	return ret
+}
+
+func fileNames(files map[string]string) []string {
+	var ret []string
+	for k := range files {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestGenerateDepsEmit(t *testing.T) {
+	files := generatedFiles(t, depsRequest(t), codegen.Options{DepsDir: "shared", SkipDocs: true})
+	assert.Equal(t, []string{
+		"pkg/dispatch.libsonnet",
+		"pkg/field-constraints.libsonnet",
+		"pkg/formats.libsonnet",
+		"pkg/generator.libsonnet",
+		"pkg/options.libsonnet",
+		"pkg/regex.libsonnet",
+		"pkg/testdata.deps/top-message.libsonnet",
+		"pkg/time.libsonnet",
+		"pkg/validators.libsonnet",
+		"pkg/well-known.libsonnet",
+		"shared/pkg/dispatch.libsonnet",
+		"shared/pkg/field-constraints.libsonnet",
+		"shared/pkg/formats.libsonnet",
+		"shared/pkg/generator.libsonnet",
+		"shared/pkg/options.libsonnet",
+		"shared/pkg/regex.libsonnet",
+		"shared/pkg/testdata.deps.lib/lib-inner.libsonnet",
+		"shared/pkg/testdata.deps.lib/lib-kind.libsonnet",
+		"shared/pkg/testdata.deps.lib/lib.libsonnet",
+		"shared/pkg/time.libsonnet",
+		"shared/pkg/validators.libsonnet",
+		"shared/pkg/well-known.libsonnet",
+		"shared/types.libsonnet",
+		"types.libsonnet",
+	}, fileNames(files))
+	assert.Contains(t, files["pkg/validators.libsonnet"], `'testdata.deps.lib.Lib': (import '../shared/pkg/testdata.deps.lib/lib.libsonnet')`)
+	assert.NotContains(t, files["shared/pkg/validators.libsonnet"], `testdata.deps.TopMessage`)
+}
+
+func TestGenerateDepsEmitSkipsUnreachable(t *testing.T) {
+	// protoc passes all imports to plugins, so list them as it would
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files: []string{
+			"message.proto",
+			"validate/validate.proto",
+			"google/protobuf/descriptor.proto",
+			"google/protobuf/duration.proto",
+			"google/protobuf/timestamp.proto",
+			"google/protobuf/wrappers.proto",
+		},
+		FilesToGenerate: []string{"message.proto"},
+		IncludePaths:    []string{"testdata/genvalidate", ".."},
+	})
+	files := generatedFiles(t, req, codegen.Options{})
+	for name := range files {
+		assert.False(t, strings.HasPrefix(name, "deps/"), name)
+	}
+	assert.NotContains(t, files["pkg/validators.libsonnet"], "'validate.")
+	assert.NotContains(t, files["pkg/validators.libsonnet"], "'google.protobuf.")
+
+	// nested types of reachable messages are referenced by their library even if no field uses them
+	files = generatedFiles(t, depsRequest(t), codegen.Options{SkipDocs: true})
+	assert.Contains(t, files, "deps/pkg/testdata.deps.lib/lib-inner.libsonnet")
+	assert.Contains(t, files, "deps/pkg/testdata.deps.lib/lib-kind.libsonnet")
+	assert.NotContains(t, files, "deps/pkg/testdata.deps.lib/unused.libsonnet")
+}
+
+func TestGenerateDepsEmitExtensions(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:           []string{"testdata/extdeps/message.proto", "testdata/extdeps/lib/lib.proto"},
+		FilesToGenerate: []string{"testdata/extdeps/message.proto"},
+		IncludePaths:    []string{"."},
+	})
+	files := generatedFiles(t, req, codegen.Options{})
+	// extensions declared in generated files must not make the dependency tree refer to the main tree
+	assert.Contains(t, files, "deps/pkg/testdata.extdeps.lib/lib.libsonnet")
+	assert.NotContains(t, files, "deps/pkg/testdata.extdeps/tag.libsonnet")
+	for name, content := range files {
+		if strings.HasPrefix(name, "deps/") {
+			assert.NotContains(t, content, "testdata.extdeps.tag", name)
+		}
+	}
+}
+
+func TestGenerateDepsReference(t *testing.T) {
+	files := generatedFiles(t, depsRequest(t), codegen.Options{Deps: codegen.DepsReference, DepsDir: "vendor/protos"})
+	for name := range files {
+		assert.NotContains(t, name, "testdata.deps.lib")
+	}
+	assert.Contains(t, files["pkg/validators.libsonnet"], `'testdata.deps.lib.Lib': (import 'vendor/protos/pkg/testdata.deps.lib/lib.libsonnet')`)
+	assert.Contains(t, files, "doc/testdata.deps/top-message.html")
+	assert.NotContains(t, files["doc/testdata.deps/top-message.html"], "lib.html")
+}
+
+func TestGenerateOutputOptions(t *testing.T) {
+	files := generatedFiles(t, depsRequest(t), codegen.Options{Prefix: "vendor/protos", TypesFile: "protos.libsonnet", IndexFile: "protos.html"})
+	assert.Contains(t, files, "vendor/protos/protos.html")
+	assert.Contains(t, files, "vendor/protos/deps/protos.libsonnet")
+	assert.Contains(t, files["vendor/protos/protos.libsonnet"], `(import 'pkg/testdata.deps/top-message.libsonnet').definition`)
+	assert.Contains(t, files["vendor/protos/pkg/validators.libsonnet"], `(import '../deps/pkg/testdata.deps.lib/lib.libsonnet')`)
+	assert.Contains(t, files["vendor/protos/doc/testdata.deps/top-message.html"], `<a href="../../protos.html">Home</a>`)
+	assert.Contains(t, files["vendor/protos/doc/testdata.deps/top-message.html"], `local types = import 'protos.libsonnet';`)
+	for name := range files {
+		assert.True(t, strings.HasPrefix(name, "vendor/protos/"), name)
+	}
+
+	files = generatedFiles(t, depsRequest(t), codegen.Options{Prefix: "vendor/protos", Imports: codegen.ImportsRooted, Layout: codegen.LayoutPackage})
+	assert.Contains(t, files["vendor/protos/types.libsonnet"], `(import 'vendor/protos/pkg/testdata.deps/package.libsonnet')['testdata.deps.TopMessage'].definition`)
+	assert.Contains(t, files["vendor/protos/pkg/testdata.deps/package.libsonnet"], `local generator = import 'vendor/protos/pkg/generator.libsonnet';`)
+	assert.Contains(t, files["vendor/protos/deps/pkg/validators.libsonnet"], `(import 'vendor/protos/deps/pkg/testdata.deps.lib/package.libsonnet')`)
+	assert.Contains(t, files["vendor/protos/pkg/validators.libsonnet"], `(import 'vendor/protos/deps/pkg/testdata.deps.lib/package.libsonnet')`)
+}
+
+func TestGenerateFieldMaskTargetErrors(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"message.proto"},
+		IncludePaths: []string{"testdata/fieldmask"},
+	})
+	tests := map[string]string{
+		"testdata.fieldmask.UpdateResourceRequest.mask":     "field_mask_target testdata.fieldmask.UpdateResourceRequest.mask: field not found",
+		"testdata.fieldmask.Missing.update_mask":            "field_mask_target testdata.fieldmask.Missing.update_mask: field not found",
+		"testdata.fieldmask.UpdateResourceRequest.resource": "want singular google.protobuf.FieldMask field, got testdata.fieldmask.Resource",
+		"update_mask": "field_mask_target update_mask: want qualified field name like pkg.Message.field",
+	}
+	for field, msg := range tests {
+		t.Run(field, func(t *testing.T) {
+			opts := codegen.Options{FieldMaskTargets: map[string]string{field: "testdata.fieldmask.Resource"}}
+			_, err := codegen.NewCodeGenerator(opts).Generate(req)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), msg)
+		})
+	}
+	opts := codegen.Options{FieldMaskTargets: map[string]string{"testdata.fieldmask.UpdateResourceRequest.update_mask": "testdata.fieldmask.Nope"}}
+	_, err := codegen.NewCodeGenerator(opts).Generate(req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "target message testdata.fieldmask.Nope not found")
+}
+
+func TestGenerateAggregatedErrors(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"message.proto"},
+		IncludePaths: []string{"testdata/fieldmask"},
+	})
+	opts := codegen.Options{FieldMaskTargets: map[string]string{
+		"testdata.fieldmask.UpdateResourceRequest.resource":    "testdata.fieldmask.Nope",
+		"testdata.fieldmask.UpdateResourceRequest.update_mask": "testdata.fieldmask.Resource",
+		"update_mask": "testdata.fieldmask.Resource",
+	}}
+	_, err := codegen.NewCodeGenerator(opts).Generate(req)
+	require.Error(t, err)
+	var modelErr *model.Error
+	require.ErrorAs(t, err, &modelErr)
+	assert.Equal(t, model.Location{File: "message.proto", Line: 27, Column: 3}, modelErr.Location)
+	assert.Equal(t, strings.Join([]string{
+		"message.proto:27:3: testdata.fieldmask.UpdateResourceRequest.resource: field_mask_target: want singular google.protobuf.FieldMask field, got testdata.fieldmask.Resource",
+		"message.proto:27:3: testdata.fieldmask.UpdateResourceRequest.resource: field_mask_target: target message testdata.fieldmask.Nope not found",
+		"field_mask_target update_mask: want qualified field name like pkg.Message.field",
+	}, "\n"), err.Error())
+}
+
+func TestGenerateFileNameCollisions(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"message.proto"},
+		IncludePaths: []string{"testdata/filenames"},
+	})
+	_, err := codegen.NewCodeGenerator(codegen.Options{}).Generate(req)
+	require.Error(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"message.proto:20:1: testdata.filenames.FooBar: file name testdata.filenames/foo-bar is already used by testdata.filenames.Foo.Bar, rename the type or set file_names=verbatim",
+		"message.proto:9:1: testdata.filenames.Http_Config: file name testdata.filenames/http-config is already used by testdata.filenames.HTTPConfig, rename the type or set file_names=verbatim",
+	}, "\n"), err.Error())
+
+	files := generatedFiles(t, req, codegen.Options{FileNames: codegen.FileNamesVerbatim})
+	assert.Contains(t, files, "pkg/testdata.filenames/FooBar.libsonnet")
+	assert.Contains(t, files, "pkg/testdata.filenames/Foo.Bar.libsonnet")
+
+	// names that only differ in case collide on case-insensitive file systems in both modes
+	req = testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"case.proto"},
+		IncludePaths: []string{"testdata/filenames/case"},
+	})
+	_, err = codegen.NewCodeGenerator(codegen.Options{}).Generate(req)
+	require.Error(t, err)
+	assert.Equal(t, "case.proto:9:1: testdata.filenames.case.HttpConfig: file name testdata.filenames.case/http-config is already used by testdata.filenames.case.HTTPConfig, rename the type", err.Error())
+	_, err = codegen.NewCodeGenerator(codegen.Options{FileNames: codegen.FileNamesVerbatim}).Generate(req)
+	require.Error(t, err)
+	assert.Equal(t, "case.proto:9:1: testdata.filenames.case.HttpConfig: file name testdata.filenames.case/HttpConfig only differs in case from testdata.filenames.case/HTTPConfig used by testdata.filenames.case.HTTPConfig, which is the same file on case-insensitive file systems, rename the type", err.Error())
+}
+
+func TestGenerateLayouts(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"a/common.proto", "service.proto"},
+		IncludePaths: []string{"testdata/bundles"},
+	})
+	tests := map[codegen.Layout][]string{
+		codegen.LayoutFile:    {"pkg/testdata.bundles/common.libsonnet", "pkg/testdata.bundles/service.libsonnet"},
+		codegen.LayoutPackage: {"pkg/testdata.bundles/package.libsonnet"},
+	}
+	for layout, libraries := range tests {
+		t.Run(string(layout), func(t *testing.T) {
+			files := generatedFiles(t, req, codegen.Options{Layout: layout, SkipDocs: true})
+			var got []string
+			for _, name := range fileNames(files) {
+				if strings.HasPrefix(name, "pkg/testdata.bundles/") {
+					got = append(got, name)
+				}
+			}
+			assert.Equal(t, libraries, got)
+			lib := strings.TrimPrefix(libraries[0], "pkg/")
+			assert.Contains(t, files["pkg/validators.libsonnet"], `'testdata.bundles.First': (import '`+lib+`')['testdata.bundles.First']`)
+			assert.Contains(t, files["types.libsonnet"], `First: (import 'pkg/`+lib+`')['testdata.bundles.First'].definition`)
+		})
+	}
+
+	req = testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"a/common.proto", "b/common.proto"},
+		IncludePaths: []string{"testdata/bundles"},
+	})
+	_, err := codegen.NewCodeGenerator(codegen.Options{Layout: codegen.LayoutFile}).Generate(req)
+	require.Error(t, err)
+	assert.Equal(t, "proto files a/common.proto and b/common.proto of package testdata.bundles both use the bundle name testdata.bundles/common, rename one of the files or set layout=package", err.Error())
+	_, err = codegen.NewCodeGenerator(codegen.Options{Layout: codegen.LayoutPackage}).Generate(req)
+	require.NoError(t, err)
+}
+
+func TestGenerateProto3Optional(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"message.proto"},
+		IncludePaths: []string{"testdata/genvalidate", ".", ".."},
+	})
+	res, err := codegen.NewCodeGenerator(codegen.Options{}).Generate(req)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL), res.GetSupportedFeatures())
+	files := generatedFiles(t, req, codegen.Options{})
+	assert.NotContains(t, files["pkg/testdata.genvalidate/top-message.libsonnet"], `"_owner"`)
+	assert.Regexp(t, `<td>owner</td>\s*<td>\s*optional`, files["doc/testdata.genvalidate/top-message.html"])
+}
+
+func TestGenerateProto2Docs(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"message.proto"},
+		IncludePaths: []string{"testdata/proto2"},
+	})
+	doc := generatedFiles(t, req, codegen.Options{})["doc/testdata.proto2/config.html"]
+	assert.Regexp(t, `<td>retries</td>\s*<td>\s*int32\s*</td>\s*<td></td>\s*<td>\s*&nbsp;\s*</td>\s*<td>\s*<code>3</code>`, doc)
+	assert.Regexp(t, `<td>name</td>\s*<td>\s*string\s*</td>\s*<td></td>\s*<td>\s*yes&nbsp;`, doc)
+	assert.Contains(t, doc, ".withRetries(3)")
+	assert.Contains(t, doc, "config-entry.html")
+}
+
+// renderGolden renders the files of a response in order. Files copied from the static directory are only listed by name.
+func renderGolden(res *pluginpb.CodeGeneratorResponse) string {
+	var b strings.Builder
+	for _, f := range res.GetFile() {
+		b.WriteString("=== " + f.GetName() + "\n")
+		if static, err := os.ReadFile(path.Join("static", path.Base(f.GetName()))); err == nil && string(static) == f.GetContent() {
+			b.WriteString("(static)\n")
+			continue
+		}
+		b.WriteString(f.GetContent())
+		if !strings.HasSuffix(f.GetContent(), "\n") {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// TestGenerateGolden ensures that repeated runs produce byte-identical responses that match the golden file. Run
+// with -update to regenerate the golden file after intentional changes to generated code.
+func TestGenerateGolden(t *testing.T) {
+	req := depsRequest(t)
+	var first []byte
+	var res *pluginpb.CodeGeneratorResponse
+	for i := 0; i < 10; i++ {
+		var err error
+		res, err = codegen.NewCodeGenerator(codegen.Options{}).Generate(req)
+		require.NoError(t, err)
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(res)
+		require.NoError(t, err)
+		if first == nil {
+			first = b
+		}
+		require.Equal(t, first, b, "response for run %d differs from the first run", i)
+	}
+	goldenFile := "testdata/golden/deps.golden"
+	actual := renderGolden(res)
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll(path.Dir(goldenFile), 0o755))
+		require.NoError(t, os.WriteFile(goldenFile, []byte(actual), 0o644))
+	}
+	expected, err := os.ReadFile(goldenFile)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), actual)
+}
@@ -19,6 +19,7 @@ package codegen
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"regexp"
@@ -39,7 +40,7 @@ var _ = htmlTemplateFor("header", `
 <body>
 {{ if ne .StylesPath "doc" }}
 <div class='crumb'>
-	<a href="../../index.html">Home</a>
+	<a href="../../{{.IndexFile}}">Home</a>
 </div>
 {{end}}
 <h1>{{.Title}}</h1>
@@ -58,16 +59,8 @@ func generateFile(t *template.Template, data interface{}) (string, error) {
 	return b.String(), nil
 }
 
-func mustGenerateFile(t *template.Template, data interface{}) string {
-	ret, err := generateFile(t, data)
-	if err != nil {
-		panic(err)
-	}
-	return ret
-}
-
 var enumDocTemplate = htmlTemplateFor("enum", `
-{{template "header" (headerValues .Object.QualifiedName "..")}}
+{{template "header" (headerValues .Object.QualifiedName ".." .IndexFile)}}
 
 <h2>Values</h2>
 
@@ -80,7 +73,7 @@ var enumDocTemplate = htmlTemplateFor("enum", `
 <h2>Example</h2>
 
 <pre class='example'>
-local types = import 'types.libsonnet';
+local types = import '{{.TypesImport}}';
 types.{{.Object.QualifiedName}}.{{.Object.NameForFirstValue}}
 </pre>
 
@@ -90,20 +83,47 @@ types.{{.Object.QualifiedName}}.{{.Object.NameForFirstValue}}
 type enumTemplateData struct {
 	TypeLinkMap *typeLinkMap
 	Object      model.Type
+	IndexFile   string // the name of the documentation entry point
+	TypesImport string // the import path of the jsonnet entry point
 }
 
-func (c *CodeGenerator) generateEnumDocs(e *model.Enum, typeLinks *typeLinkMap) *pluginpb.CodeGeneratorResponse_File {
-	content := mustGenerateFile(enumDocTemplate, enumTemplateData{
+func (c *CodeGenerator) generateEnumDocs(t *tree, e *model.Enum, typeLinks *typeLinkMap) (*pluginpb.CodeGeneratorResponse_File, error) {
+	content, err := generateFile(enumDocTemplate, enumTemplateData{
 		TypeLinkMap: typeLinks,
 		Object:      e,
+		IndexFile:   c.indexFile(),
+		TypesImport: c.importPath(t, "", c.typesFile()),
 	})
+	if err != nil {
+		return nil, err
+	}
 	return &pluginpb.CodeGeneratorResponse_File{
-		Name:    proto.String(docPath + "/" + filePathForType(e) + ".html"),
+		Name:    proto.String(docPath + "/" + c.filePathForType(e) + ".html"),
 		Content: proto.String(content),
-	}
+	}, nil
+}
+
+// wellKnownExamples are examples of the JSON forms of well-known types that are not represented as objects.
+var wellKnownExamples = map[string]string{
+	"google.protobuf.Timestamp": `'1972-01-01T10:00:20.021Z'`,
+	"google.protobuf.Duration":  `'1.5s'`,
+	"google.protobuf.FieldMask": `'name,config.maxSize'`,
+	"google.protobuf.Empty":     `{}`,
+	"google.protobuf.Struct":    `{ key: 'value' }`,
+	"google.protobuf.Value":     `'value'`,
+	"google.protobuf.ListValue": `['value']`,
+	"google.protobuf.NullValue": `null`,
 }
 
 func (c *CodeGenerator) fieldExample(fld *model.Field) string {
+	if ex, ok := wellKnownExamples[fld.TypeName()]; ok {
+		return ex
+	}
+	if fld.HasDefault() && fld.FieldType() == model.FieldTypePrimitive {
+		if b, err := json.Marshal(fld.DefaultValue()); err == nil {
+			return string(b)
+		}
+	}
 	switch {
 	case fld.TypeName() == "bool",
 		fld.TypeName() == "google.protobuf.BoolValue":
@@ -123,9 +143,15 @@ func (c *CodeGenerator) fieldExample(fld *model.Field) string {
 	case fld.FieldType() == model.FieldTypeMessage:
 		return fmt.Sprintf("_m_(types.%s)", fld.TypeName())
 	case fld.FieldType() == model.FieldTypeEnum:
-		t := c.TypeMap[fld.TypeName()]
-		e := t.GetEnum()
-		return fmt.Sprintf("_e_(types.%s.%s)", fld.TypeName(), e.NameForFirstValue())
+		t, ok := c.TypeMap[fld.TypeName()]
+		if !ok {
+			return "0"
+		}
+		name := t.GetEnum().NameForFirstValue()
+		if fld.HasDefault() {
+			name = fld.DefaultValue().(string)
+		}
+		return fmt.Sprintf("_e_(types.%s.%s)", fld.TypeName(), name)
 	default:
 		return "1"
 	}
@@ -133,11 +159,14 @@ func (c *CodeGenerator) fieldExample(fld *model.Field) string {
 
 var linkRegex = regexp.MustCompile(`_([me])_\((.+?)\)`)
 
-func (c *CodeGenerator) messageExampleHTML(m *model.Message, tlm *typeLinkMap) (string, error) {
+func (c *CodeGenerator) messageExampleHTML(t *tree, m *model.Message, tlm *typeLinkMap) (string, error) {
 	var example bytes.Buffer
-	example.WriteString(fmt.Sprintf("local types = import '%s';\n\n", typesFile))
+	example.WriteString(fmt.Sprintf("local types = import '%s';\n\n", c.importPath(t, "", c.typesFile())))
 	example.WriteString(fmt.Sprintf("types.%s", m.QualifiedName()))
 	for _, field := range m.Fields() {
+		if field.IsExtension() {
+			continue // extensions have no setters
+		}
 		example.WriteString("\n    ")
 		example.WriteString(".")
 		example.WriteString(field.SetterName())
@@ -186,7 +215,7 @@ func (c *CodeGenerator) messageExampleHTML(m *model.Message, tlm *typeLinkMap) (
 }
 
 var messageDocTemplate = htmlTemplateFor("message", `
-{{template "header" (headerValues .Object.QualifiedName "..")}}
+{{template "header" (headerValues .Object.QualifiedName ".." .IndexFile)}}
 
 {{$root := . }}
 
@@ -237,6 +266,7 @@ code.
 		<th>Type</th>
 		<th>One-of group</th>
 		<th>Required</th>
+		<th>Default</th>
 		<th>Constraints</th>
 	</tr>
 </thead>
@@ -246,6 +276,7 @@ code.
 	<tr>
 		<td>{{.Name}}</td>
 		<td>
+			{{with .IsOptional}}optional{{end}}
 			{{with .IsList}}[]{{end}}
 			{{with .IsMap}}map[string]{{end}}
 			{{with $root.TypeLinkMap.Link .TypeName}}
@@ -258,6 +289,9 @@ code.
 		<td>
 			{{with .IsRequired}}yes{{end}}&nbsp;
 		</td>
+		<td>
+			{{if .HasDefault}}<code>{{terseJson .DefaultValue}}</code>{{end}}
+		</td>
 		<td>
 			<code>{{with .Constraints}}{{terseJson .}}{{end}}</code>
 		</td>
@@ -275,26 +309,31 @@ type messageTemplateData struct {
 	Example template.HTML
 }
 
-func (c *CodeGenerator) generateMessageDocs(m *model.Message, typeLinks *typeLinkMap) *pluginpb.CodeGeneratorResponse_File {
-	example, err := c.messageExampleHTML(m, typeLinks)
+func (c *CodeGenerator) generateMessageDocs(t *tree, m *model.Message, typeLinks *typeLinkMap) (*pluginpb.CodeGeneratorResponse_File, error) {
+	example, err := c.messageExampleHTML(t, m, typeLinks)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("example: %w", err)
 	}
-	content := mustGenerateFile(messageDocTemplate, messageTemplateData{
+	content, err := generateFile(messageDocTemplate, messageTemplateData{
 		enumTemplateData: enumTemplateData{
 			TypeLinkMap: typeLinks,
 			Object:      m,
+			IndexFile:   c.indexFile(),
+			TypesImport: c.importPath(t, "", c.typesFile()),
 		},
 		Example: template.HTML(example),
 	})
+	if err != nil {
+		return nil, err
+	}
 	return &pluginpb.CodeGeneratorResponse_File{
-		Name:    proto.String(docPath + "/" + filePathForType(m) + ".html"),
+		Name:    proto.String(docPath + "/" + c.filePathForType(m) + ".html"),
 		Content: proto.String(content),
-	}
+	}, nil
 }
 
 var indexTemplate = htmlTemplateFor("index", `
-{{template "header" (headerValues "Home" "doc")}}
+{{template "header" (headerValues "Home" "doc" "")}}
 <ul>
 {{range $k, $v := .TypeLinkMap.Map}}
 <li><a href="doc/{{$v}}.html">{{$k}}</a></li>
@@ -307,12 +346,15 @@ type indexTemplateData struct {
 	TypeLinkMap *typeLinkMap
 }
 
-func (c *CodeGenerator) generateDocIndex(typeLinks *typeLinkMap) *pluginpb.CodeGeneratorResponse_File {
-	content := mustGenerateFile(indexTemplate, indexTemplateData{
+func (c *CodeGenerator) generateDocIndex(typeLinks *typeLinkMap) (*pluginpb.CodeGeneratorResponse_File, error) {
+	content, err := generateFile(indexTemplate, indexTemplateData{
 		TypeLinkMap: typeLinks,
 	})
+	if err != nil {
+		return nil, err
+	}
 	return &pluginpb.CodeGeneratorResponse_File{
-		Name:    proto.String(docIndexFile),
+		Name:    proto.String(c.indexFile()),
 		Content: proto.String(content),
-	}
+	}, nil
 }
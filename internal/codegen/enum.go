@@ -22,15 +22,14 @@ import (
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
-// enumTemplate is the code gen template for an enum type
-var enumTemplate = templateFor(`
-// Enum type: {{.QualifiedName}}
-// Definition generated by protoc-gen-jsonnet. DO NOT EDIT.
-local type = '{{.QualifiedName}}';
+// enumBody is the code gen template for the library object of an enum type, used both in its own file and in bundles.
+const enumBody = `{{define "enum"}}local type = '{{.QualifiedName}}';
 local map = {{ json .Map }};
 
 local reverseMap = {{ json .ReverseMap }};
 
+local values = {{ json .ValueMap }};
+
 local validator = function (input, ctx='') (
 	local context = if ctx == '' then type else ctx;
 	local v = std.toString(input);
@@ -39,20 +38,38 @@ local validator = function (input, ctx='') (
 	else error '%s: invalid value %s for enum %s' % [ context, v, type ]
 );
 
+// openValidator also accepts numbers that are not defined by the enum, for fields with defined_only=false.
+local openValidator = function (input, ctx='') (
+	local isInt32 = std.type(input) == 'number' && std.floor(input) == input && input >= -2147483648 && input <= 2147483647;
+	if isInt32 then input else validator(input, ctx)
+);
+
 {
 	definition: map + {
 		_new:: function (obj={}) error '%s: the _new method may not be used on enum types' % '{{.QualifiedName}}',
 		_validate:: validator,
 	},
 	validator:: validator,
+	openValidator:: openValidator,
+	values:: values,
 }
+{{end}}`
+
+// enumTemplate is the code gen template for an enum type
+var enumTemplate = templateFor(enumBody + `
+// Enum type: {{.QualifiedName}}
+// Definition generated by protoc-gen-jsonnet. DO NOT EDIT.
+{{template "enum" .}}
 `)
 
 // generateEnum generates code for an enum type.
-func (c *CodeGenerator) generateEnum(e *model.Enum) *pluginpb.CodeGeneratorResponse_File {
-	content := mustGenerateJsonnet(enumTemplate, e)
+func (c *CodeGenerator) generateEnum(e *model.Enum) (*pluginpb.CodeGeneratorResponse_File, error) {
+	content, err := generateJsonnet(enumTemplate, e)
+	if err != nil {
+		return nil, err
+	}
 	return &pluginpb.CodeGeneratorResponse_File{
-		Name:    proto.String(pkgPath + "/" + filePathForType(e) + ".libsonnet"),
+		Name:    proto.String(pkgPath + "/" + c.filePathForType(e) + ".libsonnet"),
 		Content: proto.String(content),
-	}
+	}, nil
 }
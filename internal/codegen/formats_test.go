@@ -0,0 +1,255 @@
+package codegen_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestFormats(t *testing.T) {
+	tests := map[string]struct {
+		valid   []string
+		invalid []string
+	}{
+		"email": {
+			valid:   []string{"a@b.co", "first.last+tag@example.com", "Name <x_y@host>", "!#$%&'*+/=?^_`{|}~-@x.org"},
+			invalid: []string{"", "a", "@b.com", "a@", "a..b@c.com", ".a@b.com", "a@b@c.com", "a b@c.com", "a@-b.com"},
+		},
+		"hostname": {
+			valid:   []string{"localhost", "a.b.c", "EXAMPLE.com", "example.com.", "1-2.x9"},
+			invalid: []string{"", ".", "a..b", "-a.com", "a-.com", "a_b.com", "ex ample.com", "☺.com"},
+		},
+		"ipv4": {
+			valid:   []string{"0.0.0.0", "127.0.0.1", "255.255.255.255"},
+			invalid: []string{"", "1.2.3", "1.2.3.4.5", "256.1.1.1", "01.1.1.1", "1.2.3.a", "1..2.3", "::1"},
+		},
+		"ipv6": {
+			valid: []string{
+				"::", "::1", "1::", "2001:db8::8a2e:370:7334", "1:2:3:4:5:6:7:8", "::ffff:192.0.2.1", "1:2:3:4:5:6:1.2.3.4",
+				"FE80::1",
+			},
+			invalid: []string{
+				"", "1.2.3.4", "1:2:3:4:5:6:7", "1:2:3:4:5:6:7:8:9", "1::2::3", ":::", "12345::", "1:2:3:4:5:6:7::8:9",
+				"::1.2.3.4:1", "fe80::1%eth0", "[::1]", "g::1",
+			},
+		},
+		"ip": {
+			valid:   []string{"10.0.0.1", "::1"},
+			invalid: []string{"", "localhost", "10.0.0.1/8"},
+		},
+		"address": {
+			valid:   []string{"10.0.0.1", "::1", "example.com"},
+			invalid: []string{"", "a_b", "[::1]"},
+		},
+		"uri": {
+			valid:   []string{"http://example.com", "urn:isbn:0451450523", "https://[::1]:80/p?q=a%20b#f", "mailto:a@b.c"},
+			invalid: []string{"", "/path", "example.com", "1http://x", "http://a b", "http://x/%zz", "http://x#a#b"},
+		},
+		"uriRef": {
+			valid:   []string{"", "/path", "../a?b#c", "http://x/y", "a/b:c", "#frag"},
+			invalid: []string{"a b", "a:b c", "1a:b", "%2"},
+		},
+		"uuid": {
+			valid:   []string{"00000000-0000-0000-0000-000000000000", "F47AC10B-58cc-4372-A567-0e02b2c3d479"},
+			invalid: []string{"", "f47ac10b58cc4372a5670e02b2c3d479", "g47ac10b-58cc-4372-a567-0e02b2c3d479", "{f47ac10b-58cc-4372-a567-0e02b2c3d479}"},
+		},
+		"httpHeaderName": {
+			valid:   []string{"content-type", ":path", "X-Custom_1"},
+			invalid: []string{"", ":", "a b", "a:b", "a\x00"},
+		},
+		"httpHeaderValue": {
+			valid:   []string{"", "text/html; charset=utf-8", "tab\tok", "☺"},
+			invalid: []string{"a\nb", "a\rb", "a\x00", "a\x7f"},
+		},
+	}
+	type result struct {
+		Valid   []bool `json:"valid"`
+		Invalid []bool `json:"invalid"`
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, err := json.Marshal(map[string]interface{}{"fn": name, "valid": test.valid, "invalid": test.invalid})
+			require.NoError(t, err)
+			vm := regexVM()
+			vm.TLACode("test", string(b))
+			out, err := vm.EvaluateAnonymousSnippet("formats-test", `
+				local formats = import 'formats.libsonnet';
+				function(test) {
+					valid: std.map(formats[test.fn], test.valid),
+					invalid: std.map(formats[test.fn], test.invalid),
+				}
+			`)
+			require.NoError(t, err)
+			var r result
+			require.NoError(t, json.Unmarshal([]byte(out), &r))
+			for i, v := range test.valid {
+				assert.True(t, r.Valid[i], "want %q to be valid", v)
+			}
+			for i, v := range test.invalid {
+				assert.False(t, r.Invalid[i], "want %q to be invalid", v)
+			}
+		})
+	}
+}
+
+// TestBase64Decode ensures that base64 strings are decoded in the same way as the Go implementation of the proto3
+// JSON mapping.
+func TestBase64Decode(t *testing.T) {
+	inputs := []string{
+		"", "AQ", "AQ==", "AQI", "AQI=", "AQID", "Af7/", "Af7_", "-_-_", "+/+/", "Af7_+A==", "A", "AQ=", "AQ===",
+		"AQID====", "=", "====", "A=B=", "AQ==AQ==", "aGVsbG8gd29ybGQ", "aGVsbG8gd29ybGQ=", "a b=", "☺☺☺☺",
+	}
+	type testCase struct {
+		Input string `json:"input"`
+		Bytes []int  `json:"bytes"`
+	}
+	var cases []testCase
+	for _, in := range inputs {
+		enc := base64.StdEncoding
+		if strings.ContainsAny(in, "-_") {
+			enc = base64.URLEncoding
+		}
+		if len(in)%4 != 0 {
+			enc = enc.WithPadding(base64.NoPadding)
+		}
+		c := testCase{Input: in}
+		if b, err := enc.DecodeString(in); err == nil {
+			c.Bytes = []int{}
+			for _, x := range b {
+				c.Bytes = append(c.Bytes, int(x))
+			}
+		}
+		cases = append(cases, c)
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("base64-test", `
+		local formats = import 'formats.libsonnet';
+		function(cases) [c for c in cases if formats.base64Decode(c.input) != c.bytes]
+	`)
+	require.NoError(t, err)
+	var failed []testCase
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
+
+// TestFieldMaskPaths ensures that field masks are parsed in the same way as the Go implementation of the proto3 JSON
+// mapping.
+func TestFieldMaskPaths(t *testing.T) {
+	inputs := []string{
+		"", " ", "a", "fooBar", "fooBar.baz", "a,b", " a,b.c ", "a, b", "a,,b", ",", "a.", ".a", "a..b", "foo_bar", "1a",
+		"a1", "FooBar", "a-b", "☺", "a b", "a,b,c.dEf.g",
+	}
+	type testCase struct {
+		Input string   `json:"input"`
+		Paths []string `json:"paths"`
+	}
+	var cases []testCase
+	for _, in := range inputs {
+		c := testCase{Input: in}
+		var fm fieldmaskpb.FieldMask
+		if err := protojson.Unmarshal([]byte(strconv.Quote(in)), &fm); err == nil {
+			c.Paths = []string{}
+			trimmed := strings.TrimSpace(in)
+			if trimmed != "" {
+				c.Paths = strings.Split(trimmed, ",")
+			}
+			require.Len(t, c.Paths, len(fm.GetPaths()))
+		}
+		cases = append(cases, c)
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("fieldmask-test", `
+		local formats = import 'formats.libsonnet';
+		function(cases) [c for c in cases if formats.fieldMaskPaths(c.input) != c.paths]
+	`)
+	require.NoError(t, err)
+	var failed []testCase
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
+
+// TestParseNumber ensures that numeric strings are parsed in the same way as the Go implementation of the proto3 JSON
+// mapping for double values.
+func TestParseNumber(t *testing.T) {
+	inputs := []string{
+		"0", "-0", "1", "-1", "1.5", "-1.5", "1e3", "1E3", "1e+3", "1e-3", "1.25e2", "0.001", "123456789012345678901234567890",
+		"1.7976931348623157e308", "1.7976931348623159e308", "-1.7976931348623157e308", "1e308", "1e309", "10e308",
+		"0.1e310", "0.01e310", "1e-400", "0e999999", "0.000e5", "01", "1.", ".5", "+1", " 1", "1 ", "1e", "1e+", "--1",
+		"1.5.5", "1e5e5", "0x10", "NaN", "Infinity", "", "-", "1_000",
+	}
+	type testCase struct {
+		Input  string   `json:"input"`
+		Number *float64 `json:"number"`
+	}
+	var cases []testCase
+	for _, in := range inputs {
+		c := testCase{Input: in}
+		var v wrapperspb.DoubleValue
+		if err := protojson.Unmarshal([]byte(strconv.Quote(in)), &v); err == nil && in != "NaN" && in != "Infinity" {
+			n := v.GetValue()
+			c.Number = &n
+		}
+		cases = append(cases, c)
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("number-test", `
+		local formats = import 'formats.libsonnet';
+		function(cases) [c for c in cases if formats.parseNumber(c.input) != c.number]
+	`)
+	require.NoError(t, err)
+	var failed []testCase
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
+
+// TestCompareIntegerStrings ensures that integer strings are compared exactly, including values of 64-bit types that
+// cannot be represented as doubles.
+func TestCompareIntegerStrings(t *testing.T) {
+	values := []string{
+		"0", "-0", "1", "-1", "9", "10", "-10", "9007199254740992", "9007199254740993", "-9007199254740993",
+		"9223372036854775807", "-9223372036854775808", "18446744073709551615",
+	}
+	type testCase struct {
+		A      string `json:"a"`
+		B      string `json:"b"`
+		Result int    `json:"result"`
+	}
+	var cases []testCase
+	for _, a := range values {
+		for _, b := range values {
+			x, _ := new(big.Int).SetString(a, 10)
+			y, _ := new(big.Int).SetString(b, 10)
+			cases = append(cases, testCase{A: a, B: b, Result: x.Cmp(y)})
+		}
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("compare-test", `
+		local formats = import 'formats.libsonnet';
+		function(cases) [c for c in cases if formats.compareIntegerStrings(c.a, c.b) != c.result]
+	`)
+	require.NoError(t, err)
+	var failed []testCase
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
@@ -17,18 +17,29 @@
 package codegen
 
 import (
+	"path"
+
 	"github.com/splunk/protobuf-jsonnet/internal/model"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
-// messageTemplate is the code gen template for a protobuf message.
-var messageTemplate = templateFor(`
-// Message type: {{.QualifiedName}}
-// Definition generated by protoc-gen-jsonnet. DO NOT EDIT.
-{{$root := .}}
+// messageJsonnetData is the data for the message template.
+type messageJsonnetData struct {
+	*model.Message
+	Generator string // the import path of the generator library
+	ref       func(t model.Type) string
+}
+
+// Ref returns the expression for the library object of a nested type.
+func (d *messageJsonnetData) Ref(t model.Type) string {
+	return d.ref(t)
+}
+
+// messageBody is the code gen template for the library object of a protobuf message. It expects a generator local
+// to be in scope so that the object can be used both in its own file and in bundles.
+const messageBody = `{{define "message"}}{{$root := .}}
 local type = '{{$root.QualifiedName}}';
-local generator = import '../generator.libsonnet';
 local fields = {{json .FieldMeta}};
 local oneOfs = {{json .OneOfs}};
 local validator = generator(type, fields, oneOfs);
@@ -36,10 +47,10 @@ local validator = generator(type, fields, oneOfs);
 {
 	definition: {
 		{{- range .NestedEnums}}
-			{{.Name}}:: (import '{{fileNameForType .}}.libsonnet').definition,
+			{{.Name}}:: {{$root.Ref .}}.definition,
 		{{- end}}
 		{{- range .NestedMessages}}
-			{{.Name}}:: (import '{{fileNameForType .}}.libsonnet').definition,
+			{{.Name}}:: {{$root.Ref .}}.definition,
 		{{- end}}
 
 		// methods
@@ -50,18 +61,40 @@ local validator = generator(type, fields, oneOfs);
 		_validate:: function () validator.validateAll(self),
 		_normalize:: function (kind='') validator.normalizeAll(self, kind),
 		{{- range .Fields}}
+		{{- if not .IsExtension}}
 			{{.SetterName}}:: function (val) validator.validateField(self + { '{{.Name}}': val }, '{{.Name}}', type + '.{{.SetterName}}'),
 		{{- end}}
+		{{- end}}
 	},
 	validator:: validator.validateAll,
+	structureValidator:: validator.validateStructure,
 	normalizer: validator.normalizeAll,
+	fields:: fields,
 }
+{{end}}`
+
+// messageTemplate is the code gen template for a protobuf message.
+var messageTemplate = templateFor(messageBody + `
+// Message type: {{.QualifiedName}}
+// Definition generated by protoc-gen-jsonnet. DO NOT EDIT.
+local generator = import '{{.Generator}}';
+{{template "message" .}}
 `)
 
-func (c *CodeGenerator) generateMessage(m *model.Message) *pluginpb.CodeGeneratorResponse_File {
-	content := mustGenerateJsonnet(messageTemplate, m)
+func (c *CodeGenerator) generateMessage(t *tree, m *model.Message) (*pluginpb.CodeGeneratorResponse_File, error) {
+	dir := path.Dir(path.Join(pkgPath, c.filePathForType(m)))
+	content, err := generateJsonnet(messageTemplate, &messageJsonnetData{
+		Message:   m,
+		Generator: c.importPath(t, dir, generatorJsonnetFile),
+		ref: func(n model.Type) string {
+			return c.libraryExpr(c.importPath(t, dir, path.Join(pkgPath, c.filePathForType(n))), n)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
 	return &pluginpb.CodeGeneratorResponse_File{
-		Name:    proto.String(pkgPath + "/" + filePathForType(m) + ".libsonnet"),
+		Name:    proto.String(pkgPath + "/" + c.filePathForType(m) + ".libsonnet"),
 		Content: proto.String(content),
-	}
+	}, nil
 }
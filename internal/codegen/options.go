@@ -0,0 +1,255 @@
+/*
+   Copyright 2022 Splunk Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package codegen
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DepsMode controls how types defined in dependencies of the files to generate are handled.
+type DepsMode string
+
+const (
+	// DepsEmit generates the dependencies that generated types refer to as a separate, self-contained tree under the
+	// deps directory.
+	DepsEmit DepsMode = "emit"
+	// DepsReference does not generate dependencies but imports them from a pre-generated tree
+	// that is expected to be found on the jsonnet library path.
+	DepsReference DepsMode = "reference"
+)
+
+// FileNameStyle controls how the names of generated files are derived from type names.
+type FileNameStyle string
+
+const (
+	// FileNamesKebab uses the kebab-cased nested name of a type, for example foo-bar for Foo.Bar.
+	FileNamesKebab FileNameStyle = "kebab"
+	// FileNamesVerbatim uses the nested name of a type as is, for example Foo.Bar.
+	FileNamesVerbatim FileNameStyle = "verbatim"
+)
+
+// Layout controls how the libraries of types are grouped into files.
+type Layout string
+
+const (
+	// LayoutType generates a library file for every message and enum.
+	LayoutType Layout = "type"
+	// LayoutFile generates a library file for every proto file, with all the types defined in it.
+	LayoutFile Layout = "file"
+	// LayoutPackage generates a library file for every proto package, with all the types defined in it.
+	LayoutPackage Layout = "package"
+)
+
+// ImportStyle controls how generated files import each other.
+type ImportStyle string
+
+const (
+	// ImportsRelative imports files by their path relative to the importing file.
+	ImportsRelative ImportStyle = "relative"
+	// ImportsRooted imports files by their path relative to the output directory, which must be on the jsonnet
+	// library path.
+	ImportsRooted ImportStyle = "rooted"
+)
+
+// Options are code generator Options. The zero value produces the default output.
+type Options struct {
+	SkipDocs bool     // do not generate HTML documentation
+	Deps     DepsMode // how to handle dependencies, defaults to DepsEmit
+	DepsDir  string   // the directory of the dependency tree, see depsDir for defaults
+
+	FileNames FileNameStyle // how file names are derived from type names, defaults to FileNamesKebab
+	Layout    Layout        // how libraries are grouped into files, defaults to LayoutType
+
+	Prefix    string      // the directory under the output directory for all generated files
+	TypesFile string      // the name of the jsonnet entry point, defaults to types.libsonnet
+	IndexFile string      // the name of the documentation entry point, defaults to index.html
+	Imports   ImportStyle // how generated files import each other, defaults to ImportsRelative
+
+	SkipValidations    bool     // ignore all protoc-gen-validate rules
+	ValidationsInclude []string // if not empty, only honor validation rules for these packages or messages
+	ValidationsExclude []string // ignore validation rules for these packages or messages
+
+	FieldMaskTargets        map[string]string // messages referred to by FieldMask paths keyed by qualified field name
+	StrictAny               bool              // require the @type of Any values to be set and resolve to a known type
+	NormalizeNumericStrings bool              // convert numeric strings in float and double fields to numbers on normalize
+}
+
+// optionSetter sets a single option from its string value.
+type optionSetter func(o *Options, value string) error
+
+// withCheck returns a setter that runs the supplied check on the value before setting it.
+func (s optionSetter) withCheck(check func(value string) error) optionSetter {
+	return func(o *Options, value string) error {
+		if err := check(value); err != nil {
+			return err
+		}
+		return s(o, value)
+	}
+}
+
+// boolOption returns a setter for a boolean option. A key specified without a value is treated as true.
+func boolOption(set func(o *Options, v bool)) optionSetter {
+	return func(o *Options, value string) error {
+		if value == "" {
+			set(o, true)
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("want boolean value, got %q", value)
+		}
+		set(o, b)
+		return nil
+	}
+}
+
+// stringOption returns a setter for a string option that requires a non-empty value. Options that may be repeated
+// append each value in their setter, since the parameter is already split on commas.
+func stringOption(set func(o *Options, v string)) optionSetter {
+	return func(o *Options, value string) error {
+		if value == "" {
+			return fmt.Errorf("value required")
+		}
+		set(o, value)
+		return nil
+	}
+}
+
+// pairOption returns a setter for an option that may be repeated, with each value a key=value pair added to a map.
+func pairOption(add func(o *Options, k, v string)) optionSetter {
+	return stringOption(func(o *Options, v string) {
+		key, value, _ := strings.Cut(v, "=")
+		add(o, key, value)
+	}).withCheck(func(value string) error {
+		key, v, _ := strings.Cut(value, "=")
+		if key == "" || v == "" {
+			return fmt.Errorf("want key=value, got %q", value)
+		}
+		return nil
+	})
+}
+
+// dirOption returns a setter for a directory option that must be a relative path within the output directory.
+func dirOption(set func(o *Options, v string)) optionSetter {
+	return stringOption(func(o *Options, v string) {
+		set(o, path.Clean(v))
+	}).withCheck(func(value string) error {
+		p := path.Clean(value)
+		if path.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+			return fmt.Errorf("want a relative directory under the output directory, got %q", value)
+		}
+		return nil
+	})
+}
+
+// fileOption returns a setter for the name of a file in a directory of the output, which may not contain a directory.
+func fileOption(set func(o *Options, v string)) optionSetter {
+	return stringOption(set).withCheck(func(value string) error {
+		if value == "." || value == ".." || strings.ContainsAny(value, "/\\") {
+			return fmt.Errorf("want a file name without directory, got %q", value)
+		}
+		return nil
+	})
+}
+
+// enumOption returns a setter for an option that only allows the supplied values.
+func enumOption(set func(o *Options, v string), allowed ...string) optionSetter {
+	return func(o *Options, value string) error {
+		for _, a := range allowed {
+			if value == a {
+				set(o, value)
+				return nil
+			}
+		}
+		return fmt.Errorf("want one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+}
+
+// optionSetters is the table of all supported plugin parameters keyed by name.
+var optionSetters = map[string]optionSetter{
+	"skip_docs": boolOption(func(o *Options, v bool) { o.SkipDocs = v }),
+	"deps":      enumOption(func(o *Options, v string) { o.Deps = DepsMode(v) }, string(DepsEmit), string(DepsReference)),
+	"deps_dir":  dirOption(func(o *Options, v string) { o.DepsDir = v }),
+	"file_names": enumOption(func(o *Options, v string) {
+		o.FileNames = FileNameStyle(v)
+	}, string(FileNamesKebab), string(FileNamesVerbatim)),
+	"layout": enumOption(func(o *Options, v string) {
+		o.Layout = Layout(v)
+	}, string(LayoutType), string(LayoutFile), string(LayoutPackage)),
+
+	"prefix":     dirOption(func(o *Options, v string) { o.Prefix = v }),
+	"types_file": fileOption(func(o *Options, v string) { o.TypesFile = v }),
+	"index_file": fileOption(func(o *Options, v string) { o.IndexFile = v }),
+	"imports": enumOption(func(o *Options, v string) {
+		o.Imports = ImportStyle(v)
+	}, string(ImportsRelative), string(ImportsRooted)),
+
+	"skip_validations": boolOption(func(o *Options, v bool) { o.SkipValidations = v }),
+	"validations_include": stringOption(func(o *Options, v string) {
+		o.ValidationsInclude = append(o.ValidationsInclude, v)
+	}),
+	"validations_exclude": stringOption(func(o *Options, v string) {
+		o.ValidationsExclude = append(o.ValidationsExclude, v)
+	}),
+	"strict_any": boolOption(func(o *Options, v bool) { o.StrictAny = v }),
+	"normalize_numeric_strings": boolOption(func(o *Options, v bool) {
+		o.NormalizeNumericStrings = v
+	}),
+	"field_mask_target": pairOption(func(o *Options, k, v string) {
+		if o.FieldMaskTargets == nil {
+			o.FieldMaskTargets = map[string]string{}
+		}
+		o.FieldMaskTargets[k] = v
+	}),
+}
+
+func optionNames() []string {
+	var ret []string
+	for k := range optionSetters {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ParseOptions parses the parameter string passed by protoc to the plugin (as set by
+// --jsonnet_opt or --jsonnet_out=<params>:<dir>) into options. The parameter is a comma-separated
+// list of key=value pairs. An error is returned for unknown keys or invalid values.
+func ParseOptions(param string) (Options, error) {
+	var opts Options
+	for _, kv := range strings.Split(param, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(kv, "=")
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		set, ok := optionSetters[key]
+		if !ok {
+			return Options{}, fmt.Errorf("unknown parameter %q, valid parameters are %s", key, strings.Join(optionNames(), ", "))
+		}
+		if err := set(&opts, value); err != nil {
+			return Options{}, fmt.Errorf("parameter %s: %v", key, err)
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,144 @@
+package codegen_test
+
+import (
+	"testing"
+
+	"github.com/splunk/protobuf-jsonnet/internal/codegen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		param  string
+		result codegen.Options
+		err    string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "bool",
+			param:  "skip_docs=true",
+			result: codegen.Options{SkipDocs: true},
+		},
+		{
+			name:   "bool_no_value",
+			param:  "skip_docs",
+			result: codegen.Options{SkipDocs: true},
+		},
+		{
+			name:   "spaces_and_empty_entries",
+			param:  " skip_docs = false ,,",
+			result: codegen.Options{},
+		},
+		{
+			name:   "deps",
+			param:  "deps=reference,deps_dir=shared/protos/",
+			result: codegen.Options{Deps: codegen.DepsReference, DepsDir: "shared/protos"},
+		},
+		{
+			name:  "bad_deps",
+			param: "deps=inline",
+			err:   `parameter deps: want one of emit, reference, got "inline"`,
+		},
+		{
+			name:  "bad_deps_dir",
+			param: "deps_dir=../shared",
+			err:   `parameter deps_dir: want a relative directory under the output directory, got "../shared"`,
+		},
+		{
+			name:   "file_names",
+			param:  "file_names=verbatim",
+			result: codegen.Options{FileNames: codegen.FileNamesVerbatim},
+		},
+		{
+			name:  "bad_file_names",
+			param: "file_names=snake",
+			err:   `parameter file_names: want one of kebab, verbatim, got "snake"`,
+		},
+		{
+			name:   "layout",
+			param:  "layout=package",
+			result: codegen.Options{Layout: codegen.LayoutPackage},
+		},
+		{
+			name:  "bad_layout",
+			param: "layout=bundle",
+			err:   `parameter layout: want one of type, file, package, got "bundle"`,
+		},
+		{
+			name:  "output",
+			param: "prefix=company/protos/,types_file=protos.libsonnet,index_file=protos.html,imports=rooted",
+			result: codegen.Options{
+				Prefix:    "company/protos",
+				TypesFile: "protos.libsonnet",
+				IndexFile: "protos.html",
+				Imports:   codegen.ImportsRooted,
+			},
+		},
+		{
+			name:  "bad_types_file",
+			param: "types_file=lib/types.libsonnet",
+			err:   `parameter types_file: want a file name without directory, got "lib/types.libsonnet"`,
+		},
+		{
+			name:  "bad_imports",
+			param: "imports=absolute",
+			err:   `parameter imports: want one of relative, rooted, got "absolute"`,
+		},
+		{
+			name:  "validations",
+			param: "skip_validations=false,validations_include=a.b,validations_include=c,validations_exclude=a.b.Foo",
+			result: codegen.Options{
+				ValidationsInclude: []string{"a.b", "c"},
+				ValidationsExclude: []string{"a.b.Foo"},
+			},
+		},
+		{
+			name:  "field_mask_target",
+			param: "field_mask_target=a.Update.mask=a.Resource,field_mask_target=a.Patch.mask=a.Other",
+			result: codegen.Options{
+				FieldMaskTargets: map[string]string{"a.Update.mask": "a.Resource", "a.Patch.mask": "a.Other"},
+			},
+		},
+		{
+			name:   "strict_any",
+			param:  "strict_any",
+			result: codegen.Options{StrictAny: true},
+		},
+		{
+			name:   "normalize_numeric_strings",
+			param:  "normalize_numeric_strings=true",
+			result: codegen.Options{NormalizeNumericStrings: true},
+		},
+		{
+			name:  "bad_field_mask_target",
+			param: "field_mask_target=a.Update.mask",
+			err:   `parameter field_mask_target: want key=value, got "a.Update.mask"`,
+		},
+		{
+			name:  "bad_bool",
+			param: "skip_docs=maybe",
+			err:   `parameter skip_docs: want boolean value, got "maybe"`,
+		},
+		{
+			name:  "unknown",
+			param: "skip_docs,foo=bar",
+			err:   `unknown parameter "foo", valid parameters are deps, deps_dir, field_mask_target, file_names, imports, index_file, layout, normalize_numeric_strings, prefix, skip_docs, skip_validations, strict_any, types_file, validations_exclude, validations_include`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := codegen.ParseOptions(test.param)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.result, opts)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package codegen_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func regexVM() *jsonnet.VM {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{JPaths: []string{"static"}})
+	return vm
+}
+
+// TestRegexMatchesGo ensures that the jsonnet regex implementation produces the same results as the
+// Go implementation of RE2 for supported syntax.
+func TestRegexMatchesGo(t *testing.T) {
+	patterns := []string{
+		``, `^$`, `abc`, `^abc`, `abc$`, `a.c`, `(?s)a.c`, `^a*$`, `^(ab|cd)+$`, `^a{2}$`, `^a{2,3}$`, `^a{2,}$`, `a+?c`,
+		`^[^0-9]*$`, `\d{3}-\d{4}`, `^[\w.-]+$`, `[^\W]`, `[\d\s]+$`, `[[:alpha:]]+`, `^[[:^digit:]]+$`, `^[a-]+$`, `^[]a]+$`,
+		`(?i)^hello$`, `^(?i:he)llo$`, `(?m)^b$`, `\bfoo\b`, `\Bfoo`, `\A\d+\z`, `\x41`, `\x{263a}+`, `^\.$`, `\\`, `{`,
+		`^(a|b|)c$`, `^(a*)*$`, `^(?P<x>a+)b$`, `^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`,
+	}
+	inputs := []string{
+		"", "a", "aa", "aaa", "aaaa", "abc", "ABC", "xabcx", "a\nb", "b", "c", "ab", "abbc", "cd", "ac", "a\nc",
+		"hello", "HeLLo", "HEllo", "foo bar", "foobar", "123-4567", "joe@example.com", "a.b", ".", "\\", "{",
+		"my-host-1", "-bad", "good-", "]a", "a-a", "  ", "\t\n", "_id9", "☺☺", "A",
+	}
+	type testCase struct {
+		Pattern string `json:"pattern"`
+		Input   string `json:"input"`
+		Match   bool   `json:"match"`
+	}
+	var cases []testCase
+	for _, p := range patterns {
+		re := regexp.MustCompile(p)
+		for _, in := range inputs {
+			cases = append(cases, testCase{Pattern: p, Input: in, Match: re.MatchString(in)})
+		}
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("regex-test", `
+		local regex = import 'regex.libsonnet';
+		function(cases) [c for c in cases if regex.match(c.pattern, c.input) != c.match]
+	`)
+	require.NoError(t, err)
+	var failed []testCase
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
+
+func TestRegexErrors(t *testing.T) {
+	tests := map[string]string{
+		`a**`:    `regex: invalid nested repetition operator in pattern "a**"`,
+		`(a`:     `regex: missing closing ) in pattern "(a"`,
+		`a)`:     `regex: unexpected ) in pattern "a)"`,
+		`[a`:     `regex: missing closing ] in pattern "[a"`,
+		`*a`:     `regex: missing argument to repetition operator in pattern "*a"`,
+		`a{3,2}`: `regex: invalid repeat count in pattern "a{3,2}"`,
+		`[z-a]`:  `regex: invalid character class range in pattern "[z-a]"`,
+		`\pL`:    `regex: unsupported escape \p in pattern "\pL"`,
+	}
+	vm := regexVM()
+	for pattern, msg := range tests {
+		t.Run(pattern, func(t *testing.T) {
+			_, err := vm.EvaluateAnonymousSnippet("regex-test", fmt.Sprintf(`(import 'regex.libsonnet').match(%q, 'a')`, pattern))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), msg)
+		})
+	}
+}
+
+func TestRegexNativeOverride(t *testing.T) {
+	vm := regexVM()
+	vm.NativeFunction(&jsonnet.NativeFunction{
+		Name:   "regexMatch",
+		Params: ast.Identifiers{"pattern", "input"},
+		Func: func(args []interface{}) (interface{}, error) {
+			return regexp.MatchString(args[0].(string), args[1].(string))
+		},
+	})
+	out, err := vm.EvaluateAnonymousSnippet("regex-test", `
+		local constraints = import 'field-constraints.libsonnet';
+		local meta = { type: 'string', containerType: '', constraints: { String_: { pattern: '^\\p{Lu}' } } };
+		constraints(meta, 'Ünicode', 'ctx')
+	`)
+	require.NoError(t, err)
+	assert.Equal(t, "\"Ünicode\"\n", out)
+}
@@ -2,10 +2,14 @@ local valMap = import 'validators.libsonnet';
 local wellKnown = import 'well-known.libsonnet';
 local typeMap = valMap + wellKnown;  // wellKnown will override keys in valMap for well-known types
 
-local dispatch = function(to='validator', trace=true) (
+// returns a function that calls the validator or normalizer for a type, or the fallback function for types that do not
+// have one. Types without either are passed through, with a warning if trace is set, or cause an error if strict is set.
+local dispatch = function(to='validator', trace=true, strict=false, fallback=null) (
   local unknown = function(typeName) (
     function(input, ctx) (
-      if trace then
+      if strict then
+        error '%s: no %s found for type %s' % [ctx, to, typeName]
+      else if trace then
         std.trace('WARN: %s: no %s found for type %s' % [ctx, to, typeName], input)
       else
         input
@@ -14,7 +18,8 @@ local dispatch = function(to='validator', trace=true) (
 
   function(typeName, input, ctx='') (
     local context = if ctx == '' then typeName else ctx;
-    local fn = if std.objectHas(typeMap, typeName) && std.objectHasAll(typeMap[typeName], to) then typeMap[typeName][to] else unknown(typeName);
+    local has = function(name) name != null && std.objectHas(typeMap, typeName) && std.objectHasAll(typeMap[typeName], name);
+    local fn = if has(to) then typeMap[typeName][to] else if has(fallback) then typeMap[typeName][fallback] else unknown(typeName);
     fn(input, context)
   )
 );
@@ -1,45 +1,153 @@
-local valOrDefault = function(obj, name, def={}) if std.objectHas(obj, name) then obj[name] else def;
+local dispatch = import 'dispatch.libsonnet';
+local formats = import 'formats.libsonnet';
+local regex = import 'regex.libsonnet';
+local time = import 'time.libsonnet';
+local validators = import 'validators.libsonnet';
+local validate = dispatch();
+
+// returns the named value from the object or the default if it is not present. Null values, produced for unset
+// oneofs in rules, are treated as missing.
+local valOrDefault = function(obj, name, def={}) if std.objectHas(obj, name) && obj[name] != null then obj[name] else def;
 
 local friendlyTypes = {
   'google.protobuf.StringValue': 'string',
+  'google.protobuf.BytesValue': 'bytes',
+  'google.protobuf.BoolValue': 'bool',
+  'google.protobuf.FloatValue': 'float',
+  'google.protobuf.DoubleValue': 'double',
+  'google.protobuf.Int32Value': 'int32',
+  'google.protobuf.Int64Value': 'int64',
+  'google.protobuf.UInt32Value': 'uint32',
+  'google.protobuf.UInt64Value': 'uint64',
+  'google.protobuf.Timestamp': 'timestamp',
+  'google.protobuf.Duration': 'duration',
+  'google.protobuf.Any': 'any',
 };
 
 local friendlyTypeName = function(meta) if std.objectHas(friendlyTypes, meta.type) then friendlyTypes[meta.type] else meta.type;
 
 local getValue = function(input) if std.type(input) == 'object' && std.objectHas(input, 'value') then input.value else input;
 
+// formats a value for error messages, quoting strings
+local fmtValue = function(v) if std.type(v) == 'string' then '"%s"' % v else std.toString(v);
+local fmtValues = function(arr, fmt=fmtValue) '[%s]' % std.join(', ', std.map(fmt, arr));
+
 local identity = function(meta, input, ctx) input;
 local inputIdentity = function(input) function(meta, val, ctx) input;
 
+// common constraints
+
+// returns checks for const, in and not_in constraints that compare and format values with the supplied ordering.
+local equalityChecksFor = function(ord) {
+  local isMember = function(input, values) std.length(std.filter(function(v) ord.equalTo(input, v), values)) > 0,
+  const: function(typeMeta, input, ctx) (
+    if !std.objectHas(typeMeta.constraints, 'const') then input else (
+      local constValue = typeMeta.constraints.const;
+      if !ord.equalTo(input, constValue)
+      then
+        error '%s: const %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), ord.fmtBound(constValue), ord.fmtValue(input)]
+      else
+        input
+    )
+  ),
+  'in': function(typeMeta, input, ctx) (
+    if !std.objectHas(typeMeta.constraints, 'in') then input else (
+      local inValues = typeMeta.constraints['in'];
+      if !isMember(input, inValues) then
+        error '%s: %s in value: want one of %s, got %s' % [ctx, friendlyTypeName(typeMeta), fmtValues(inValues, ord.fmtBound), ord.fmtValue(input)]
+      else
+        input
+    )
+  ),
+  not_in: function(typeMeta, input, ctx) (
+    if !std.objectHas(typeMeta.constraints, 'not_in') then input else (
+      local notInValues = typeMeta.constraints.not_in;
+      if isMember(input, notInValues) then
+        error '%s: %s not_in value: want none of %s, got %s' % [ctx, friendlyTypeName(typeMeta), fmtValues(notInValues, ord.fmtBound), ord.fmtValue(input)]
+      else
+        input
+    )
+  ),
+};
+
+local equalityChecks = equalityChecksFor({ equalTo: function(v, c) v == c, fmtBound: fmtValue, fmtValue: fmtValue });
+local constCheck = equalityChecks.const;
+local inCheck = equalityChecks['in'];
+local notInCheck = equalityChecks.not_in;
+
 // string constraints
-local constCheck = function(typeMeta, input, ctx) (
-  if !std.objectHas(typeMeta.constraints, 'const') then input else (
-    local constValue = typeMeta.constraints.const;
-    if input != constValue
-    then
-      error '%s: const %s value: want "%s", got "%s"' % [ctx, friendlyTypeName(typeMeta), constValue, std.toString(input)]
-    else
-      input
-  )
+
+// regexMatch returns true if the pattern matches the input. A native function with the same name registered with
+// the jsonnet VM takes precedence over the RE2 subset implemented in regex.libsonnet.
+local regexMatch = (
+  local native = std.native('regexMatch');
+  if native != null then native else regex.match
 );
 
-local inCheck = function(typeMeta, input, ctx) (
-  if !std.objectHas(typeMeta.constraints, 'in') then input else (
-    local inValues = typeMeta.constraints['in'];
-    if !std.member(inValues, input) then
-      error '%s: %s in value: want one of %s, got "%s"' % [ctx, friendlyTypeName(typeMeta), std.toString(inValues), std.toString(input)]
-    else
-      input
+// returns a check function for a constraint that is applied only when the constraint is present.
+local stringCheck = function(name, ok, want) function(typeMeta, input, ctx) (
+  if !std.objectHas(typeMeta.constraints, name) then input else (
+    local c = typeMeta.constraints[name];
+    if ok(input, c) then input
+    else error '%s: %s %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), name, want(input, c), fmtValue(input)]
   )
 );
 
-local notInCheck = function(typeMeta, input, ctx) (
-  if !std.objectHas(typeMeta.constraints, 'not_in') then input else (
-    local notInValues = typeMeta.constraints.not_in;
-    if std.member(notInValues, input) then
-      error '%s: %s not_in value: want none of %s, got "%s"' % [ctx, friendlyTypeName(typeMeta), std.toString(notInValues), std.toString(input)]
-    else
-      input
+local byteLength = function(s) std.length(std.encodeUTF8(s));
+local contains = function(s, sub) std.length(sub) == 0 || std.length(std.findSubstr(sub, s)) > 0;
+
+local stringLengthChecks = [
+  stringCheck('len', function(s, c) std.length(s) == c, function(s, c) 'length %d (found %d)' % [c, std.length(s)]),
+  stringCheck('min_len', function(s, c) std.length(s) >= c, function(s, c) 'length >= %d (found %d)' % [c, std.length(s)]),
+  stringCheck('max_len', function(s, c) std.length(s) <= c, function(s, c) 'length <= %d (found %d)' % [c, std.length(s)]),
+  stringCheck('len_bytes', function(s, c) byteLength(s) == c, function(s, c) 'byte length %d (found %d)' % [c, byteLength(s)]),
+  stringCheck('min_bytes', function(s, c) byteLength(s) >= c, function(s, c) 'byte length >= %d (found %d)' % [c, byteLength(s)]),
+  stringCheck('max_bytes', function(s, c) byteLength(s) <= c, function(s, c) 'byte length <= %d (found %d)' % [c, byteLength(s)]),
+];
+
+local stringContentChecks = [
+  stringCheck('pattern', function(s, c) regexMatch(c, s), function(s, c) 'match for pattern %s' % fmtValue(c)),
+  stringCheck('prefix', function(s, c) std.startsWith(s, c), function(s, c) 'prefix %s' % fmtValue(c)),
+  stringCheck('suffix', function(s, c) std.endsWith(s, c), function(s, c) 'suffix %s' % fmtValue(c)),
+  stringCheck('contains', function(s, c) contains(s, c), function(s, c) 'value containing %s' % fmtValue(c)),
+  stringCheck('not_contains', function(s, c) !contains(s, c), function(s, c) 'value not containing %s' % fmtValue(c)),
+];
+
+// patterns for the values of the KnownRegex enum, used by the well_known_regex rule.
+local knownRegexes = {
+  '1': { name: 'HTTP header name', check: formats.httpHeaderName },
+  '2': { name: 'HTTP header value', check: formats.httpHeaderValue },
+};
+
+// well-known string formats keyed by the field name of the well_known oneof, with the name of the rule and a
+// description of valid values for error messages.
+local wellKnownFormats = {
+  Email: { rule: 'email', want: 'a valid email address', check: function(s, c) formats.email(s) },
+  Hostname: { rule: 'hostname', want: 'a valid hostname', check: function(s, c) formats.hostname(s) },
+  Ip: { rule: 'ip', want: 'a valid IP address', check: function(s, c) formats.ip(s) },
+  Ipv4: { rule: 'ipv4', want: 'a valid IPv4 address', check: function(s, c) formats.ipv4(s) },
+  Ipv6: { rule: 'ipv6', want: 'a valid IPv6 address', check: function(s, c) formats.ipv6(s) },
+  Uri: { rule: 'uri', want: 'a valid absolute URI', check: function(s, c) formats.uri(s) },
+  UriRef: { rule: 'uri_ref', want: 'a valid URI reference', check: function(s, c) formats.uriRef(s) },
+  Address: { rule: 'address', want: 'a valid hostname or IP address', check: function(s, c) formats.address(s) },
+  Uuid: { rule: 'uuid', want: 'a valid UUID', check: function(s, c) formats.uuid(s) },
+  WellKnownRegex: {
+    local known = function(c) valOrDefault(knownRegexes, std.toString(c.WellKnownRegex), null),
+    rule: 'well_known_regex',
+    want: function(c) 'a valid %s' % known(c).name,
+    check: function(s, c) known(c) == null || known(c).check(s, valOrDefault(c, 'strict', true)),
+  },
+};
+
+local wellKnownCheck = function(typeMeta, input, ctx) (
+  local c = typeMeta.constraints;
+  local wk = valOrDefault(c, 'WellKnown', null);
+  local set = if wk == null then [] else [k for k in std.objectFields(wk) if std.objectHas(wellKnownFormats, k) && wk[k] != false];
+  if std.length(set) == 0 then input else (
+    local wf = wellKnownFormats[set[0]];
+    local want = if std.type(wf.want) == 'function' then wf.want(wk) else wf.want;
+    if wf.check(input, wk { strict: valOrDefault(c, 'strict', true) }) then input
+    else error '%s: %s %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), wf.rule, want, fmtValue(input)]
   )
 );
 
@@ -47,41 +155,506 @@ local validateString = function(meta, input, ctx) (
   if !std.objectHas(meta.constraints, 'String_') then input else (
     local typeMeta = { type: meta.type, constraints: meta.constraints.String_ };
     local val = getValue(input);
+    local ignore = valOrDefault(typeMeta.constraints, 'ignore_empty', false) && val == '';
+    local checkers = [constCheck] + stringLengthChecks + stringContentChecks + [
+      wellKnownCheck,
+      inCheck,
+      notInCheck,
+      inputIdentity(input),
+    ];
+    if ignore then input else std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, val)
+  )
+);
+
+// bytes constraints
+
+// returns the decoded bytes for a base64 string.
+local decodeBytes = function(s) (
+  local b = formats.base64Decode(s);
+  if b == null then error 'invalid base64 value %s' % fmtValue(s) else b
+);
+
+local hasPrefix = function(b, p) std.length(b) >= std.length(p) && b[0:std.length(p)] == p;
+local hasSuffix = function(b, p) std.length(b) >= std.length(p) && b[std.length(b) - std.length(p):std.length(b)] == p;
+local hasSubarray = function(b, sub) (
+  local n = std.length(sub);
+  n == 0 || std.length([i for i in std.range(0, std.length(b) - n) if b[i:i + n] == sub]) > 0
+);
+
+// returns a check function for a constraint that is applied to the decoded bytes, only when the constraint is present.
+local bytesCheck = function(name, ok, want) function(typeMeta, input, ctx) (
+  if !std.objectHas(typeMeta.constraints, name) then input else (
+    local c = typeMeta.constraints[name];
+    local b = decodeBytes(input);
+    if ok(b, c) then input
+    else error '%s: %s %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), name, want(b, c), fmtValue(input)]
+  )
+);
+
+// the ip formats for bytes are checked by length since the value is the binary form of the address.
+local bytesIPLengths = {
+  Ip: { rule: 'ip', lengths: [4, 16] },
+  Ipv4: { rule: 'ipv4', lengths: [4] },
+  Ipv6: { rule: 'ipv6', lengths: [16] },
+};
+
+local bytesIPCheck = function(typeMeta, input, ctx) (
+  local wk = valOrDefault(typeMeta.constraints, 'WellKnown', null);
+  local set = if wk == null then [] else [k for k in std.objectFields(wk) if std.objectHas(bytesIPLengths, k) && wk[k]];
+  if std.length(set) == 0 then input else (
+    local ip = bytesIPLengths[set[0]];
+    local n = std.length(decodeBytes(input));
+    if std.member(ip.lengths, n) then input
+    else error '%s: %s %s value: want %s bytes (found %d), got %s' % [
+      ctx,
+      friendlyTypeName(typeMeta),
+      ip.rule,
+      std.join(' or ', std.map(std.toString, ip.lengths)),
+      n,
+      fmtValue(input),
+    ]
+  )
+);
+
+local bytesConstCheck = function(typeMeta, input, ctx) (
+  if !std.objectHas(typeMeta.constraints, 'const') then input else (
+    local c = typeMeta.constraints.const;
+    if decodeBytes(input) == decodeBytes(c) then input
+    else error '%s: const %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), fmtValue(c), fmtValue(input)]
+  )
+);
+
+local bytesChecks = [
+  bytesConstCheck,
+  bytesCheck('len', function(b, c) std.length(b) == c, function(b, c) 'length %d (found %d)' % [c, std.length(b)]),
+  bytesCheck('min_len', function(b, c) std.length(b) >= c, function(b, c) 'length >= %d (found %d)' % [c, std.length(b)]),
+  bytesCheck('max_len', function(b, c) std.length(b) <= c, function(b, c) 'length <= %d (found %d)' % [c, std.length(b)]),
+  bytesCheck('pattern', function(b, c) regexMatch(c, std.decodeUTF8(b)), function(b, c) 'match for pattern %s' % fmtValue(c)),
+  bytesCheck('prefix', function(b, c) hasPrefix(b, decodeBytes(c)), function(b, c) 'prefix %s' % fmtValue(c)),
+  bytesCheck('suffix', function(b, c) hasSuffix(b, decodeBytes(c)), function(b, c) 'suffix %s' % fmtValue(c)),
+  bytesCheck('contains', function(b, c) hasSubarray(b, decodeBytes(c)), function(b, c) 'value containing %s' % fmtValue(c)),
+  bytesCheck('in', function(b, c) std.member(std.map(decodeBytes, c), b), function(b, c) 'one of %s' % fmtValues(c)),
+  bytesCheck('not_in', function(b, c) !std.member(std.map(decodeBytes, c), b), function(b, c) 'none of %s' % fmtValues(c)),
+  bytesIPCheck,
+];
+
+local validateBytes = function(meta, input, ctx) (
+  if !std.objectHas(meta.constraints, 'Bytes') then input else (
+    local typeMeta = { type: meta.type, constraints: meta.constraints.Bytes };
+    local val = getValue(input);
+    local ignore = valOrDefault(typeMeta.constraints, 'ignore_empty', false) && std.length(decodeBytes(val)) == 0;
+    local checkers = bytesChecks + [inputIdentity(input)];
+    if ignore then input else std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, val)
+  )
+);
+
+// numeric constraints
+
+// special floating point values that may be specified as strings in JSON
+local specialNumbers = ['NaN', 'Infinity', '-Infinity'];
+
+// returns the numeric value for the input, converting numeric strings to numbers. Special floating
+// point values are returned as-is.
+local numericValue = function(input) (
+  local v = getValue(input);
+  if std.type(v) == 'string' && !std.member(specialNumbers, v) then std.parseJson(v) else v
+);
+
+// comparison functions for a value that may be a special number against a bound that is always a number.
+// NaN compares false with everything.
+local lessThan = function(v, bound) if v == 'NaN' || v == 'Infinity' then false else if v == '-Infinity' then true else v < bound;
+local greaterThan = function(v, bound) if v == 'NaN' || v == '-Infinity' then false else if v == 'Infinity' then true else v > bound;
+local equalTo = function(v, bound) std.type(v) == 'number' && v == bound;
+
+// returns a check for gt, gte, lt and lte constraints using the supplied ordering of values. When both a lower and upper
+// bound are specified and the upper bound is not greater than the lower bound, the range is exclusive, that is the value
+// must be outside it.
+local rangeCheckFor = function(ord) function(typeMeta, input, ctx) (
+  local c = typeMeta.constraints;
+  local lower = if std.objectHas(c, 'gt') then { op: '>', value: c.gt, check: function(v) ord.greaterThan(v, c.gt) }
+  else if std.objectHas(c, 'gte') then { op: '>=', value: c.gte, check: function(v) ord.greaterThan(v, c.gte) || ord.equalTo(v, c.gte) }
+  else null;
+  local upper = if std.objectHas(c, 'lt') then { op: '<', value: c.lt, check: function(v) ord.lessThan(v, c.lt) }
+  else if std.objectHas(c, 'lte') then { op: '<=', value: c.lte, check: function(v) ord.lessThan(v, c.lte) || ord.equalTo(v, c.lte) }
+  else null;
+  local fmtBound = function(b) '%s %s' % [b.op, ord.fmtBound(b.value)];
+  local result = if lower == null && upper == null then { ok: true }
+  else if upper == null then { ok: lower.check(input), want: fmtBound(lower) }
+  else if lower == null then { ok: upper.check(input), want: fmtBound(upper) }
+  else if ord.greaterThan(upper.value, lower.value) then { ok: lower.check(input) && upper.check(input), want: '%s and %s' % [fmtBound(lower), fmtBound(upper)] }
+  else { ok: lower.check(input) || upper.check(input), want: '%s or %s' % [fmtBound(upper), fmtBound(lower)] };
+  if result.ok then input
+  else error '%s: %s range value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), result.want, ord.fmtValue(input)]
+);
+
+local rangeCheck = rangeCheckFor({
+  lessThan: lessThan,
+  greaterThan: greaterThan,
+  equalTo: equalTo,
+  fmtBound: std.toString,
+  fmtValue: fmtValue,
+});
+
+// constraint keys for numeric types, including wrappers
+local numericRuleKeys = {
+  float: 'Float',
+  'google.protobuf.FloatValue': 'Float',
+  double: 'Double',
+  'google.protobuf.DoubleValue': 'Double',
+  int32: 'Int32',
+  'google.protobuf.Int32Value': 'Int32',
+  int64: 'Int64',
+  'google.protobuf.Int64Value': 'Int64',
+  uint32: 'Uint32',
+  'google.protobuf.UInt32Value': 'Uint32',
+  uint64: 'Uint64',
+  'google.protobuf.UInt64Value': 'Uint64',
+  sint32: 'Sint32',
+  sint64: 'Sint64',
+  fixed32: 'Fixed32',
+  fixed64: 'Fixed64',
+  sfixed32: 'Sfixed32',
+  sfixed64: 'Sfixed64',
+};
+
+// constraint keys for 64-bit integer types. Their values and rule values are compared as integer strings, since they
+// may not be exactly representable as numbers.
+local integerRuleKeys = ['Int64', 'Uint64', 'Sint64', 'Fixed64', 'Sfixed64'];
+local isInteger64 = function(type) std.objectHas(numericRuleKeys, type) && std.member(integerRuleKeys, numericRuleKeys[type]);
+
+// returns the integer string for a 64-bit integer input that has already been validated, with -0 returned as 0.
+local integerString = function(input) (
+  local v = getValue(input);
+  local s = if std.type(v) == 'number' then '%d' % v else v;
+  if s == '-0' then '0' else s
+);
+
+local integerOrdering = {
+  lessThan: function(v, bound) formats.compareIntegerStrings(v, bound) < 0,
+  greaterThan: function(v, bound) formats.compareIntegerStrings(v, bound) > 0,
+  equalTo: function(v, bound) formats.compareIntegerStrings(v, bound) == 0,
+  fmtBound: std.toString,
+  fmtValue: std.toString,
+};
+local integerChecks = equalityChecksFor(integerOrdering) { range: rangeCheckFor(integerOrdering) };
+
+local validateNumber = function(meta, input, ctx) (
+  local key = numericRuleKeys[meta.type];
+  if !std.objectHas(meta.constraints, key) then input else (
+    local typeMeta = { type: meta.type, constraints: meta.constraints[key] };
+    local integer = std.member(integerRuleKeys, key);
+    local val = if integer then integerString(input) else numericValue(input);
+    local ignore = valOrDefault(typeMeta.constraints, 'ignore_empty', false) && val == (if integer then '0' else 0);
+    local checkers = (
+      if integer then [integerChecks.const, integerChecks.range, integerChecks['in'], integerChecks.not_in]
+      else [constCheck, rangeCheck, inCheck, notInCheck]
+    ) + [inputIdentity(input)];
+    if ignore then input else std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, val)
+  )
+);
+
+// timestamp constraints
+
+// returns the current time from the 'now' external variable, which must be set to an RFC 3339 timestamp when
+// lt_now, gt_now or within rules are used.
+local now = function(ctx) (
+  local v = std.extVar('now');
+  local t = if std.type(v) == 'string' then time.parseTimestamp(v) else null;
+  if t == null then error '%s: want RFC 3339 timestamp in external variable "now", got %s' % [ctx, fmtValue(v)] else t
+);
+
+local timeOrdering = function(fmt) {
+  lessThan: function(v, bound) time.compare(v, bound) < 0,
+  greaterThan: function(v, bound) time.compare(v, bound) > 0,
+  equalTo: function(v, bound) time.compare(v, bound) == 0,
+  fmtBound: fmt,
+  fmtValue: function(v) fmtValue(fmt(v)),
+};
+
+local timestampOrdering = timeOrdering(time.formatTimestamp);
+local durationOrdering = timeOrdering(time.formatDuration);
+
+// returns checks for const, in and not_in constraints that compare times using the supplied ordering.
+local timeConstCheck = function(ord) function(typeMeta, input, ctx) (
+  if !std.objectHas(typeMeta.constraints, 'const') then input else (
+    local c = typeMeta.constraints.const;
+    if ord.equalTo(input, c) then input
+    else error '%s: const %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), ord.fmtBound(c), ord.fmtValue(input)]
+  )
+);
+
+local timeMemberCheck = function(ord, name, want) function(typeMeta, input, ctx) (
+  if !std.objectHas(typeMeta.constraints, name) then input else (
+    local values = typeMeta.constraints[name];
+    local found = std.length(std.filter(function(v) ord.equalTo(input, v), values)) > 0;
+    if found == (name == 'in') then input
+    else error '%s: %s %s value: want %s [%s], got %s' % [
+      ctx,
+      friendlyTypeName(typeMeta),
+      name,
+      want,
+      std.join(', ', std.map(ord.fmtBound, values)),
+      ord.fmtValue(input),
+    ]
+  )
+);
+
+// checks lt_now, gt_now and within rules. When combined, the value must be within the duration before or after now.
+local timestampNowCheck = function(typeMeta, input, ctx) (
+  local c = typeMeta.constraints;
+  local ltNow = valOrDefault(c, 'lt_now', false);
+  local gtNow = valOrDefault(c, 'gt_now', false);
+  local within = valOrDefault(c, 'within', null);
+  if !ltNow && !gtNow && within == null then input else (
+    local current = now(ctx);
+    local diff = time.subtract(input, current);
+    local fail = function(rule, want) error '%s: %s %s value: want %s, got %s' % [ctx, friendlyTypeName(typeMeta), rule, want, timestampOrdering.fmtValue(input)];
+    local fmtNow = time.formatTimestamp(current);
+    if ltNow && time.compare(diff, {}) >= 0 then fail('lt_now', '< now (%s)' % fmtNow)
+    else if gtNow && time.compare(diff, {}) <= 0 then fail('gt_now', '> now (%s)' % fmtNow)
+    else if within != null && time.compare(if time.compare(diff, {}) < 0 then time.negate(diff) else diff, within) > 0 then
+      fail('within', 'within %s of now (%s)' % [time.formatDuration(within), fmtNow])
+    else input
+  )
+);
+
+local validateTimestamp = function(meta, input, ctx) (
+  if !std.objectHas(meta.constraints, 'Timestamp') then input else (
+    local typeMeta = { type: meta.type, constraints: meta.constraints.Timestamp };
+    local checkers = [
+      timeConstCheck(timestampOrdering),
+      rangeCheckFor(timestampOrdering),
+      timestampNowCheck,
+      inputIdentity(input),
+    ];
+    std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, time.parseTimestamp(input))
+  )
+);
+
+// duration constraints
+
+local validateDuration = function(meta, input, ctx) (
+  if !std.objectHas(meta.constraints, 'Duration') then input else (
+    local typeMeta = { type: meta.type, constraints: meta.constraints.Duration };
+    local checkers = [
+      timeConstCheck(durationOrdering),
+      rangeCheckFor(durationOrdering),
+      timeMemberCheck(durationOrdering, 'in', 'one of'),
+      timeMemberCheck(durationOrdering, 'not_in', 'none of'),
+      inputIdentity(input),
+    ];
+    std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, time.durationValue(input))
+  )
+);
+
+// any constraints
+
+// checks in and not_in rules against the type URL of an Any value, which is empty if @type is not set.
+local validateAny = function(meta, input, ctx) (
+  if !std.objectHas(meta.constraints, 'Any') then input else (
+    local typeMeta = { type: meta.type, constraints: meta.constraints.Any };
+    local typeURL = if std.type(input) == 'object' && std.objectHas(input, '@type') then input['@type'] else '';
     local checkers = [
-      constCheck,
       inCheck,
       notInCheck,
       inputIdentity(input),
     ];
-    std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, val)
+    std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, typeURL)
   )
 );
 
+// enum constraints
+
+// returns the number for an enum value that may be specified as a name, a number or a numeric string.
+local enumNumber = function(type, input) (
+  local values = if std.objectHas(validators, type) then validators[type].values else {};
+  if std.type(input) == 'number' then input
+  else if std.objectHas(values, input) then std.parseInt(values[input])
+  else std.parseJson(input)
+);
+
+// enum values are compared by number but reported as written, with the number added to names like ZERO (0).
+local enumChecks = equalityChecksFor({
+  equalTo: function(v, c) v.number == c,
+  fmtBound: fmtValue,
+  fmtValue: function(v) if std.type(v.input) == 'string' && !formats.isIntegerString(v.input) then '%s (%d)' % [v.input, v.number] else fmtValue(v.input),
+});
+
+local validateEnum = function(meta, input, ctx) (
+  local typeMeta = { type: meta.type, constraints: meta.constraints.Enum };
+  local checkers = [
+    enumChecks.const,
+    enumChecks['in'],
+    enumChecks.not_in,
+    inputIdentity(input),
+  ];
+  std.foldl(function(prev, check) check(typeMeta, prev, ctx), checkers, { input: input, number: enumNumber(meta.type, input) })
+);
+
 // dispatchers
 local dispatchTable = {
   string: validateString,
   'google.protobuf.StringValue': validateString,
+  bytes: validateBytes,
+  'google.protobuf.BytesValue': validateBytes,
+  'google.protobuf.Timestamp': validateTimestamp,
+  'google.protobuf.Duration': validateDuration,
+  'google.protobuf.Any': validateAny,
+} + {
+  [type]: validateNumber
+  for type in std.objectFields(numericRuleKeys)
 };
 
 local dispatchScalar = function(meta, input, ctx) (
-  local fn = valOrDefault(dispatchTable, meta.type, identity);
+  local fn = if std.objectHas(dispatchTable, meta.type) then dispatchTable[meta.type]
+  else if std.objectHas(meta.constraints, 'Enum') then validateEnum
+  else identity;
   fn(meta, input, ctx)
 );
 
+// repeated constraints
+
+// returns a key for an item such that equal values have equal keys, treating numeric strings as numbers, 64-bit
+// integers as integer strings and comparing bytes after decoding.
+local itemKey = function(meta, item) (
+  local v = if isInteger64(meta.type) then integerString(item)
+  else if std.objectHas(numericRuleKeys, meta.type) then numericValue(item)
+  else if meta.type == 'bytes' || meta.type == 'google.protobuf.BytesValue' then decodeBytes(getValue(item))
+  else getValue(item);
+  std.manifestJsonEx(v, '')
+);
+
+local countCheck = function(name, ok, want) function(constraints, input, ctx) (
+  if !std.objectHas(constraints, name) then input else (
+    local c = constraints[name];
+    local n = std.length(input);
+    if ok(n, c) then input
+    else error '%s: repeated %s value: want %s items, got %d' % [ctx, name, want(c), n]
+  )
+);
+
+local repeatedCountChecks = [
+  countCheck('min_items', function(n, c) n >= c, function(c) '>= %d' % c),
+  countCheck('max_items', function(n, c) n <= c, function(c) '<= %d' % c),
+];
+
+local uniqueCheck = function(meta) function(constraints, input, ctx) (
+  if !valOrDefault(constraints, 'unique', false) then input else (
+    local dups = std.foldl(
+      function(prev, item) (
+        local key = itemKey(meta, item);
+        if std.objectHas(prev.seen, key) then prev { dups+: [item] } else prev { seen+: { [key]: true } }
+      ),
+      input,
+      { seen: {}, dups: [] },
+    ).dups;
+    if std.length(dups) == 0 then input
+    else error '%s: repeated unique value: want unique items, got duplicate %s' % [ctx, fmtValue(getValue(dups[0]))]
+  )
+);
+
+// applies the items rules to every element of the list.
+local itemsCheck = function(meta) function(constraints, input, ctx) (
+  local items = valOrDefault(constraints, 'items');
+  local messageRules = valOrDefault(items, 'message');
+  local itemMeta = { type: meta.type, constraints: valOrDefault(items, 'Type') };
+  local check = function(i, item) (
+    local itemCtx = '%s[%d]' % [ctx, i];
+    if item == null && valOrDefault(messageRules, 'required', false) then
+      error '%s: repeated items value: want message to be set, got null' % itemCtx
+    else
+      dispatchScalar(itemMeta, item, itemCtx)
+  );
+  std.mapWithIndex(check, input)
+);
+
 local dispatchList = function(meta, input, ctx) (
-  local constraints = meta.constraints;
-  local itemsConstraints = valOrDefault(constraints, 'items');
-  local typeConstraints = valOrDefault(itemsConstraints, 'Type');
-  std.mapWithIndex(function(i, item) dispatchScalar({ type: meta.type, constraints: typeConstraints }, item, '%s[%d]' % [ctx, i]), input)
+  local constraints = valOrDefault(meta.constraints, 'Repeated');
+  local ignore = valOrDefault(constraints, 'ignore_empty', false) && std.length(input) == 0;
+  local checkers = repeatedCountChecks + [uniqueCheck(meta), itemsCheck(meta)];
+  if ignore then input else std.foldl(function(prev, check) check(constraints, prev, ctx), checkers, input)
+);
+
+// map constraints
+
+local pairsCheck = function(name, ok, want) function(meta, constraints, input, ctx) (
+  if !std.objectHas(constraints, name) then input else (
+    local c = constraints[name];
+    local n = std.length(input);
+    if ok(n, c) then input
+    else error '%s: map %s value: want %s pairs, got %d' % [ctx, name, want(c), n]
+  )
+);
+
+local mapPairsChecks = [
+  pairsCheck('min_pairs', function(n, c) n >= c, function(c) '>= %d' % c),
+  pairsCheck('max_pairs', function(n, c) n <= c, function(c) '<= %d' % c),
+];
+
+// validates every key of the map against the declared key type and applies the keys rules. Keys are always strings in
+// JSON, so integer keys are validated as integer strings and bool keys are converted to booleans first.
+local keysCheck = function(meta, constraints, input, ctx) (
+  local keyMeta = { type: meta.keyType, constraints: valOrDefault(valOrDefault(constraints, 'keys'), 'Type') };
+  local check = function(key) (
+    local keyCtx = '%s.%s (key)' % [ctx, key];
+    local value = if meta.keyType == 'bool' && (key == 'true' || key == 'false') then key == 'true' else key;
+    std.toString(dispatchScalar(keyMeta, validate(meta.keyType, value, keyCtx), keyCtx))
+  );
+  std.foldl(function(prev, key) prev { [check(key)]: input[key] }, std.objectFields(input), {})
+);
+
+// applies the values rules to every value of the map.
+local valuesCheck = function(meta, constraints, input, ctx) (
+  local values = valOrDefault(constraints, 'values');
+  local required = valOrDefault(constraints, 'no_sparse', false) || valOrDefault(valOrDefault(values, 'message'), 'required', false);
+  local valueMeta = { type: meta.type, constraints: valOrDefault(values, 'Type') };
+  local check = function(key, value) (
+    local valueCtx = '%s.%s' % [ctx, key];
+    if value == null && required then
+      error '%s: map %s value: want message to be set, got null' % [valueCtx, if valOrDefault(constraints, 'no_sparse', false) then 'no_sparse' else 'values']
+    else
+      dispatchScalar(valueMeta, value, valueCtx)
+  );
+  std.foldl(function(prev, key) prev { [key]: check(key, input[key]) }, std.objectFields(input), {})
 );
 
 local dispatchMap = function(meta, input, ctx) (
-  local constraints = meta.constraints;
-  local itemsConstraints = valOrDefault(constraints, 'values');
-  local typeConstraints = valOrDefault(itemsConstraints, 'Type');
-  std.foldl(function(prev, name) prev { [name]: dispatchScalar({ type: meta.type, constraints: typeConstraints }, input[name], '%s.%s' % [ctx, name]) },
-            std.objectFields(input),
-            {})
+  local constraints = valOrDefault(meta.constraints, 'Map');
+  local ignore = valOrDefault(constraints, 'ignore_empty', false) && std.length(input) == 0;
+  local checkers = mapPairsChecks + [keysCheck, valuesCheck];
+  if ignore then input else std.foldl(function(prev, check) check(meta, constraints, prev, ctx), checkers, input)
+);
+
+// field mask targets
+
+// returns the proto field name for a lowerCamelCase field mask path segment.
+local snakeCase = function(s) std.join('', [if std.asciiLower(c) != c then '_' + std.asciiLower(c) else c for c in std.stringChars(s)]);
+
+// returns the reason a field mask path, split into segments, does not resolve to a field of the supplied message, or
+// null if it does. Paths into messages that have not been generated, like well-known types, are not checked further.
+local resolvePath = function(type, segments) (
+  local isMessage = function(t) std.objectHas(validators, t) && std.objectHasAll(validators[t], 'fields');
+  local fields = validators[type].fields;
+  local name = snakeCase(segments[0]);
+  if !std.objectHas(fields, name) then 'no field %s in %s' % [segments[0], type]
+  else if std.length(segments) == 1 then null
+  else (
+    local meta = fields[name];
+    local singular = valOrDefault(meta, 'containerType', '') == '';
+    if singular && isMessage(meta.type) then resolvePath(meta.type, segments[1:])
+    else if singular && std.startsWith(meta.type, 'google.protobuf.') && !std.objectHas(validators, meta.type) then null
+    else 'field %s is not a singular message' % segments[0]
+  )
+);
+
+// checks that every path of a field mask resolves to a field of the target message. Syntax errors are reported by the
+// FieldMask validator.
+local fieldMaskTargetCheck = function(target, input, ctx) (
+  local paths = if std.type(input) == 'string' then formats.fieldMaskPaths(input) else null;
+  local results = if paths == null then [] else [{ path: p, reason: resolvePath(target, std.split(p, '.')) } for p in paths];
+  local bad = std.filter(function(r) r.reason != null, results);
+  if std.length(bad) == 0 then input
+  else error '%s: invalid field mask path "%s" for %s: %s' % [ctx, bad[0].path, target, bad[0].reason]
 );
 
 local dispatchTable = {
@@ -95,7 +668,10 @@ function(field, input, ctx='') (
   // refer to this object.
   local meta = {
     type: field.type,
+    keyType: valOrDefault(field, 'keyType', 'string'),
     constraints: valOrDefault(field, 'constraints'),
+    maskTarget: valOrDefault(field, 'maskTarget', ''),
   };
-  dispatchTable[field.containerType](meta, input, ctx)
+  local checked = dispatchTable[field.containerType](meta, input, ctx);
+  if meta.maskTarget == '' then checked else fieldMaskTargetCheck(meta.maskTarget, checked, ctx)
 )
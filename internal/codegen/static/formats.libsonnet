@@ -0,0 +1,190 @@
+// Checks for well-known string formats supported by protoc-gen-validate. Every function returns true if the
+// input string is valid for the format. The checks follow the protoc-gen-validate Go implementation where
+// it is well-defined, and the referenced RFCs otherwise.
+local regex = import 'regex.libsonnet';
+
+local cp = std.codepoint;
+local between = function(c, lo, hi) cp(lo) <= cp(c) && cp(c) <= cp(hi);
+local isDigit = function(c) between(c, '0', '9');
+local isHex = function(c) isDigit(c) || between(c, 'a', 'f') || between(c, 'A', 'F');
+local allChars = function(s, fn) std.length(std.filter(function(c) !fn(c), std.stringChars(s))) == 0;
+local allOf = function(arr, fn) std.length(std.filter(function(x) !fn(x), arr)) == 0;
+
+// hostname as defined by RFC 1034, without support for internationalized domain names.
+local hostname = function(s) (
+  local host = std.asciiLower(if std.endsWith(s, '.') then s[0:std.length(s) - 1] else s);
+  local validPart = function(part) (
+    local n = std.length(part);
+    n > 0 && n <= 63 && part[0] != '-' && part[n - 1] != '-' &&
+    allChars(part, function(c) between(c, 'a', 'z') || isDigit(c) || c == '-')
+  );
+  std.length(s) <= 253 && allOf(std.split(host, '.'), validPart)
+);
+
+// dotted quad IPv4 address. Leading zeros are not allowed.
+local ipv4 = function(s) (
+  local parts = std.split(s, '.');
+  local validPart = function(p) (
+    local n = std.length(p);
+    n >= 1 && n <= 3 && allChars(p, isDigit) && (p == '0' || p[0] != '0') && std.parseInt(p) <= 255
+  );
+  std.length(parts) == 4 && allOf(parts, validPart)
+);
+
+// IPv6 address as defined by RFC 4291, including embedded IPv4 addresses. Zones and surrounding brackets are not allowed.
+local ipv6 = function(s) (
+  local ellipses = std.findSubstr('::', s);
+  local groups = function(part) if part == '' then [] else std.split(part, ':');
+  local validGroup = function(g) std.length(g) >= 1 && std.length(g) <= 4 && allChars(g, isHex);
+  // returns the number of 16-bit groups represented by the supplied parts or -1 if they are invalid
+  local count = function(parts, allowIPv4) (
+    local n = std.length(parts);
+    local last = if n == 0 then '' else parts[n - 1];
+    local lastIsIPv4 = allowIPv4 && n > 0 && ipv4(last);
+    local hexParts = if lastIsIPv4 then parts[0:n - 1] else parts;
+    if !allOf(hexParts, validGroup) then -1 else std.length(hexParts) + (if lastIsIPv4 then 2 else 0)
+  );
+  if std.length(ellipses) == 0 then count(groups(s), true) == 8
+  else if std.length(ellipses) > 1 then false
+  else (
+    local left = count(groups(s[0:ellipses[0]]), false);
+    local right = count(groups(s[ellipses[0] + 2:]), true);
+    left >= 0 && right >= 0 && left + right < 8
+  )
+);
+
+local ip = function(s) ipv4(s) || ipv6(s);
+
+// email address as defined by RFC 5322, limited to the dot-atom form of the local part. The address may
+// optionally be enclosed in angle brackets after a display name.
+local email = function(s) (
+  local open = std.findSubstr('<', s);
+  local addr = if std.endsWith(s, '>') && std.length(open) > 0 then s[open[std.length(open) - 1] + 1:std.length(s) - 1] else s;
+  local at = std.findSubstr('@', addr);
+  local atext = function(c) between(c, 'a', 'z') || between(c, 'A', 'Z') || isDigit(c) || std.member("!#$%&'*+/=?^_`{|}~-", c);
+  local validLocal = function(l) std.length(l) <= 64 && allOf(std.split(l, '.'), function(atom) atom != '' && allChars(atom, atext));
+  std.length(addr) <= 254 && std.length(at) == 1 &&
+  validLocal(addr[0:at[0]]) && hostname(addr[at[0] + 1:])
+);
+
+local address = function(s) hostname(s) || ip(s);
+
+local uuid = function(s) regex.match('^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$', s);
+
+// URI character set from RFC 3986: unreserved, sub-delims, gen-delims used in paths and queries, percent encodings
+// and brackets for IP literals.
+local uriChars = "([-A-Za-z0-9._~!$&'()*+,;=:@/?\\[\\]]|%[0-9A-Fa-f]{2})";
+local uriPattern = '^[A-Za-z][-A-Za-z0-9+.]*:%s*(#%s*)?$' % [uriChars, uriChars];
+local relativeRefPattern = '^%s*(#%s*)?$' % [uriChars, uriChars];
+
+// absolute URI as defined by RFC 3986.
+local uri = function(s) regex.match(uriPattern, s);
+
+// absolute URI or relative reference as defined by RFC 3986. The first segment of a relative path may not contain
+// a colon since it would be interpreted as a scheme.
+local uriRef = function(s) (
+  local firstSegment = std.split(std.split(std.split(s, '/')[0], '?')[0], '#')[0];
+  uri(s) || (std.length(std.findSubstr(':', firstSegment)) == 0 && regex.match(relativeRefPattern, s))
+);
+
+// HTTP header names and values as defined by RFC 7230. When strict is false, only NUL, CR and LF are disallowed.
+local looseHeader = '^[^\\x00\\x0A\\x0D]*$';
+local httpHeaderName = function(s, strict=true) regex.match(if strict then "^:?[0-9a-zA-Z!#$%&'*+-.^_|~\\x60]+$" else looseHeader, s);
+local httpHeaderValue = function(s, strict=true) regex.match(if strict then '^[^\\x00-\\x08\\x0A-\\x1F\\x7F]*$' else looseHeader, s);
+
+// decodes standard or URL-safe base64 with or without padding, as accepted by the proto3 JSON mapping for bytes,
+// returning an array of bytes or null if the input is not valid. Characters from both alphabets may not be mixed.
+local base64Decode = function(s) (
+  local urlSafe = std.length(std.findSubstr('-', s)) > 0 || std.length(std.findSubstr('_', s)) > 0;
+  local extra = if urlSafe then '-_' else '+/';
+  // padding is only allowed when the length is a multiple of 4
+  local body = if std.length(s) % 4 == 0 then std.rstripChars(s, '=') else s;
+  local valid = std.length(s) - std.length(body) <= 2 && std.length(body) % 4 != 1 &&
+                allChars(body, function(c) between(c, 'a', 'z') || between(c, 'A', 'Z') || isDigit(c) || std.member(extra, c));
+  local standard = std.join('', std.map(function(c) if c == '-' then '+' else if c == '_' then '/' else c, std.stringChars(body)));
+  if !valid then null else std.base64DecodeBytes(standard + ['', '', '==', '='][std.length(body) % 4])
+);
+
+// returns the paths of a google.protobuf.FieldMask in its JSON form, comma-separated lowerCamelCase paths, or null if
+// the input is not valid. Like the Go implementation, surrounding whitespace is ignored and each path must be made up of
+// dot-separated identifiers that do not contain underscores.
+local fieldMaskPaths = function(s) (
+  local trimmed = std.stripChars(s, ' \t\n\r');
+  local paths = if trimmed == '' then [] else std.split(trimmed, ',');
+  local validIdent = function(id) (
+    id != '' && !isDigit(id[0]) && allChars(id, function(c) between(c, 'a', 'z') || between(c, 'A', 'Z') || isDigit(c))
+  );
+  if allOf(paths, function(p) allOf(std.split(p, '.'), validIdent)) then paths else null
+);
+
+// returns true if the string is an integer without leading zeros or a plus sign, as allowed by JSON.
+local isIntegerString = function(s) (
+  local digits = if std.startsWith(s, '-') then s[1:] else s;
+  digits != '' && allChars(digits, isDigit) && (digits == '0' || digits[0] != '0')
+);
+
+// compares two integer strings, returning -1, 0 or 1. Numbers with more digits are larger, and numbers with the same
+// number of digits compare the same way as their strings.
+local compareIntegerStrings = function(a, b) (
+  local negA = std.startsWith(a, '-');
+  local negB = std.startsWith(b, '-');
+  local absA = if negA then a[1:] else a;
+  local absB = if negB then b[1:] else b;
+  local cmpAbs = if std.length(absA) != std.length(absB) then std.sign(std.length(absA) - std.length(absB))
+  else if absA < absB then -1
+  else if absA > absB then 1
+  else 0;
+  if absA == '0' && absB == '0' then 0
+  else if negA != negB then (if negA then -1 else 1)
+  else if negA then -cmpAbs
+  else cmpAbs
+);
+
+// parses a JSON number literal, returning null if the input is not a valid literal or is too large for a double.
+// Magnitudes are checked using the decimal exponent of the significant digits before parsing, since jsonnet does not
+// support infinite values.
+local parseNumber = function(s) (
+  local neg = std.startsWith(s, '-');
+  local body = if neg then s[1:] else s;
+  local e = std.findSubstr('e', std.asciiLower(body));
+  local mantissa = if std.length(e) == 0 then body else body[0:e[0]];
+  local exponent = if std.length(e) == 0 then '0' else body[e[0] + 1:];
+  local expDigits = if std.startsWith(exponent, '+') || std.startsWith(exponent, '-') then exponent[1:] else exponent;
+  local dot = std.findSubstr('.', mantissa);
+  local intPart = if std.length(dot) == 0 then mantissa else mantissa[0:dot[0]];
+  local fracPart = if std.length(dot) == 0 then '0' else mantissa[dot[0] + 1:];
+  local valid = std.length(e) <= 1 && std.length(dot) <= 1 &&
+                intPart != '' && allChars(intPart, isDigit) && (intPart == '0' || intPart[0] != '0') &&
+                fracPart != '' && allChars(fracPart, isDigit) && expDigits != '' && allChars(expDigits, isDigit);
+  if !valid then null else (
+    // the value is 0.<significant digits> * 10^decimalExponent
+    local digits = intPart + fracPart;
+    local significant = std.lstripChars(digits, '0');
+    local decimalExponent = std.length(intPart) - (std.length(digits) - std.length(significant)) +
+                            (if std.startsWith(exponent, '-') then -1 else 1) * std.parseInt(expDigits);
+    local tooLarge = significant != '' && (decimalExponent > 309 ||
+                                           (decimalExponent == 309 && std.parseJson('0.' + significant) > 0.17976931348623157));
+    if tooLarge then null
+    else if significant == '' || decimalExponent < -400 then (if neg then -0 else 0)
+    else std.parseJson(s)
+  )
+);
+
+{
+  base64Decode:: base64Decode,
+  parseNumber:: parseNumber,
+  isIntegerString:: isIntegerString,
+  compareIntegerStrings:: compareIntegerStrings,
+  fieldMaskPaths:: fieldMaskPaths,
+  email:: email,
+  hostname:: hostname,
+  ip:: ip,
+  ipv4:: ipv4,
+  ipv6:: ipv6,
+  uri:: uri,
+  uriRef:: uriRef,
+  address:: address,
+  uuid:: uuid,
+  httpHeaderName:: httpHeaderName,
+  httpHeaderValue:: httpHeaderValue,
+}
@@ -42,6 +42,46 @@ local containerValidateMap = {
   map: dispatchMap($['']),
 };
 
+// validation map for enum fields that allow values not defined by the enum.
+local openEnumValidateMap = {
+  '': dispatch('openValidator'),
+  list: dispatchArray($['']),
+  map: dispatchMap($['']),
+};
+
+// validation map for fields whose message rules are skipped. Messages are still checked to be objects with valid
+// fields of the right types, like protobuf parsers do, and other types are validated as usual. Null elements are left
+// to the required and no_sparse rules.
+local structureValidateMap = {
+  '': dispatch('structureValidator', fallback='validator'),
+  element:: function(typeName, input, ctx) if input == null then input else $[''](typeName, input, ctx),
+  list: dispatchArray($.element),
+  map: dispatchMap($.element),
+};
+
+local has = function(obj, name) std.type(obj) == 'object' && std.objectHas(obj, name) && obj[name] != null;
+
+// returns the rules that apply to each element of a field: the items rules for repeated fields, the values rules for
+// maps and the field rules otherwise.
+local elementRules = function(meta) (
+  local c = meta.constraints;
+  if meta.containerType == 'list' then (if has(c, 'Repeated') && has(c.Repeated, 'items') then c.Repeated.items else {})
+  else if meta.containerType == 'map' then (if has(c, 'Map') && has(c.Map, 'values') then c.Map.values else {})
+  else { Type: c }
+);
+
+// returns true if the field constraints request that the rules of repeated message items or map values be skipped.
+local skipItems = function(meta) (
+  local rules = elementRules(meta);
+  meta.containerType != '' && has(rules, 'message') && has(rules.message, 'skip') && rules.message.skip
+);
+
+// returns true if the field is an enum that explicitly allows values not defined by the enum.
+local openEnum = function(meta) (
+  local rules = elementRules(meta);
+  has(rules, 'Type') && has(rules.Type, 'Enum') && has(rules.Type.Enum, 'defined_only') && !rules.Type.Enum.defined_only
+);
+
 // normalization map for various container types.
 local containerNormalizeMap = {
   '': dispatch('normalizer', false),
@@ -128,17 +168,19 @@ local generator = function(type, fields0, oneOfs) (
     applyChecksOverFields(userInput, checker)
   );
 
-  // checks a single field for type and constraints correctness if it exists in the object.
-  // Either canonical or aliased names can be specified.
-  local checkField = function(input, name, ctx) (
+  // checks a single field for type and constraints correctness if it exists in the object. Only the type is checked
+  // if rules is false. Either canonical or aliased names can be specified.
+  local checkField = function(input, name, ctx, rules=true) (
     local meta = allFields[name];
-    local fn = containerValidateMap[meta.containerType];
+    local fn = if !rules || skipItems(meta) then structureValidateMap[meta.containerType]
+    else if openEnum(meta) then openEnumValidateMap[meta.containerType]
+    else containerValidateMap[meta.containerType];
     if !std.objectHas(input, name)
     then input
     else (
       local innerCtx = '%s.%s' % [ctx, name];
       local val0 = fn(meta.type, input[name], innerCtx);
-      local val1 = constraintsCheck(meta, val0, innerCtx);
+      local val1 = if rules then constraintsCheck(meta, val0, innerCtx) else val0;
       input { [name]: val1 }
     )
   );
@@ -148,6 +190,11 @@ local generator = function(type, fields0, oneOfs) (
     std.foldl(function(prev, name) checkField(prev, name, ctx), std.objectFields(userInput), userInput)
   );
 
+  // check function to check field values against their type without applying their rules.
+  local checkFieldTypes = function(userInput, ctx) (
+    std.foldl(function(prev, name) checkField(prev, name, ctx, false), std.objectFields(userInput), userInput)
+  );
+
   // expanded a list of canonical field names to include both canonical and JSON field names in the output
   local expandFieldNames(flds) = std.flatMap(function(name) fields[name].allowedNames, flds);
 
@@ -198,6 +245,18 @@ local generator = function(type, fields0, oneOfs) (
       ]);
       checker(input, context)
     ),
+    // validateStructure only checks that the input can be parsed as the message, for fields whose rules are skipped.
+    validateStructure: function(input0, ctx='') (
+      local context = if ctx == '' then type else ctx;
+      local input = if std.type(input0) == 'object' then input0 else error '%s: want object, found %s' % [context, std.type(input0)];
+      local checker = compositeChecks([
+        checkValidFields,
+        checkAliases,
+        checkFieldTypes,
+        checkOneOfs,
+      ]);
+      checker(input, context)
+    ),
     validateField: function(input0, name, ctx='') (
       local input = if std.type(input0) == 'object' then input0 else error '%s: want object, found %s' % [ctx, std.type(input0)];
       local checker = compositeChecks([
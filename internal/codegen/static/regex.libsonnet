@@ -0,0 +1,393 @@
+// A regular expression matcher for a subset of the RE2 syntax used by protoc-gen-validate patterns.
+// Patterns are compiled into a program that is run as a Thompson NFA simulation, such that matching
+// takes time linear in the length of the input and does not overflow the jsonnet stack.
+//
+// Supported syntax:
+//   x                     literal characters, escaped punctuation, \t \n \r \f \v \a \xHH \x{HHHH}
+//   .                     any character except newline (including newline with the s flag)
+//   [xyz] [^a-z]          character classes with ranges, negation, perl classes and [:alpha:] ASCII classes
+//   \d \D \w \W \s \S     ASCII perl character classes
+//   ^ $ \A \z \b \B       text anchors and ASCII word boundaries (^ and $ match at line boundaries with the m flag)
+//   x* x+ x? x{n} x{n,} x{n,m}
+//                         repetitions, optionally followed by ? for non-greedy matching
+//   xy x|y                concatenation and alternation
+//   (x) (?:x) (?P<n>x)    capturing and non-capturing groups (captures are not reported)
+//   (?flags) (?flags:x)   set flags for the rest of the group, or for x. Flags are i, m and s, optionally cleared with -
+//
+// Unicode classes (\p), octal escapes, backreferences and \Q...\E quoting are not supported and result in an error.
+// Matches are unanchored, that is the pattern may match anywhere in the input as with RE2.
+
+local maxCodepoint = 1114111;
+
+local fail = function(pattern, msg) error 'regex: %s in pattern "%s"' % [msg, pattern];
+
+// character class ranges
+local cp = std.codepoint;
+local range = function(from, to) [cp(from), cp(to)];
+local digitRanges = [range('0', '9')];
+local wordRanges = [range('0', '9'), range('A', 'Z'), range('_', '_'), range('a', 'z')];
+local spaceRanges = [[9, 10], [12, 13], [32, 32]];
+
+local posixClasses = {
+  alnum: [range('0', '9'), range('A', 'Z'), range('a', 'z')],
+  alpha: [range('A', 'Z'), range('a', 'z')],
+  ascii: [[0, 127]],
+  blank: [[9, 9], [32, 32]],
+  cntrl: [[0, 31], [127, 127]],
+  digit: digitRanges,
+  graph: [[33, 126]],
+  lower: [range('a', 'z')],
+  print: [[32, 126]],
+  punct: [[33, 47], [58, 64], [91, 96], [123, 126]],
+  space: [[9, 13], [32, 32]],
+  upper: [range('A', 'Z')],
+  word: wordRanges,
+  xdigit: [range('0', '9'), range('A', 'F'), range('a', 'f')],
+};
+
+// returns ranges that match all characters not matched by the supplied ranges.
+local complement = function(ranges) (
+  local sorted = std.sort(ranges, function(r) r[0]);
+  local state = std.foldl(
+    function(prev, r) (
+      if r[0] > prev.next then { next: std.max(prev.next, r[1] + 1), out: prev.out + [[prev.next, r[0] - 1]] }
+      else { next: std.max(prev.next, r[1] + 1), out: prev.out }
+    ),
+    sorted,
+    { next: 0, out: [] },
+  );
+  if state.next <= maxCodepoint then state.out + [[state.next, maxCodepoint]] else state.out
+);
+
+// adds ASCII case variants of the supplied ranges.
+local foldCase = function(ranges) (
+  local shift = function(r, lo, hi, delta) (
+    local from = std.max(r[0], lo);
+    local to = std.min(r[1], hi);
+    if from <= to then [[from + delta, to + delta]] else []
+  );
+  ranges + std.flatMap(function(r) shift(r, cp('a'), cp('z'), -32) + shift(r, cp('A'), cp('Z'), 32), ranges)
+);
+
+local inRanges = function(ranges, c) std.length(std.filter(function(r) r[0] <= c && c <= r[1], ranges)) > 0;
+
+local isWordChar = function(c) c != null && inRanges(wordRanges, c);
+
+local hexValue = function(pattern, s) (
+  local digits = std.stringChars(std.asciiLower(s));
+  if std.length(digits) == 0 then fail(pattern, 'invalid hex escape') else
+    std.foldl(
+      function(prev, d) (
+        local v = std.findSubstr(d, '0123456789abcdef');
+        if std.length(v) == 0 then fail(pattern, 'invalid hex escape') else prev * 16 + v[0]
+      ),
+      digits,
+      0
+    )
+);
+
+// parser. Parse functions take the current position and return an object with the parsed node and the next position.
+local parse = function(pattern) (
+  local chars = std.stringChars(pattern);
+  local n = std.length(chars);
+  local at = function(pos) if pos < n then chars[pos] else null;
+
+  local classNode = function(ranges, neg=false, flags={}) {
+    t: 'class',
+    neg: neg,
+    ranges: if std.objectHas(flags, 'i') && flags.i then foldCase(ranges) else ranges,
+  };
+  local literal = function(c, flags) classNode([[c, c]], false, flags);
+
+  // parses an escape sequence starting after the backslash, returning the ranges for a literal or perl class.
+  // Assertions are returned as the `assertion` attribute.
+  local parseEscape = function(pos, inClass) (
+    local c = at(pos);
+    local simple = { n: 10, t: 9, r: 13, f: 12, v: 11, a: 7 };
+    local perl = { d: digitRanges, w: wordRanges, s: spaceRanges };
+    local asserts = { b: 'wordb', B: 'nwordb', A: 'bot', z: 'eot' };
+    if c == null then fail(pattern, 'trailing backslash')
+    else if std.objectHas(simple, c) then { ranges: [[simple[c], simple[c]]], pos: pos + 1 }
+    else if std.objectHas(perl, c) then { ranges: perl[c], pos: pos + 1 }
+    else if std.objectHas(perl, std.asciiLower(c)) then { ranges: complement(perl[std.asciiLower(c)]), pos: pos + 1 }
+    else if !inClass && std.objectHas(asserts, c) then { assertion: asserts[c], pos: pos + 1 }
+    else if c == 'x' then (
+      if at(pos + 1) == '{' then (
+        local end = std.findSubstr('}', pattern[pos + 2:]);
+        if std.length(end) == 0 then fail(pattern, 'invalid hex escape')
+        else (
+          local v = hexValue(pattern, pattern[pos + 2:pos + 2 + end[0]]);
+          { ranges: [[v, v]], pos: pos + 3 + end[0] }
+        )
+      ) else (
+        local v = hexValue(pattern, pattern[pos + 1:pos + 3]);
+        if pos + 3 > n then fail(pattern, 'invalid hex escape') else { ranges: [[v, v]], pos: pos + 3 }
+      )
+    )
+    else if inRanges(posixClasses.alnum, cp(c)) then fail(pattern, 'unsupported escape \\%s' % c)
+    else { ranges: [[cp(c), cp(c)]], pos: pos + 1 }
+  );
+
+  // parses a character class starting after the opening bracket.
+  local parseClass = function(pos0, flags) (
+    local neg = at(pos0) == '^';
+    local start = if neg then pos0 + 1 else pos0;
+    // parses a single class character returning its codepoint or a set of ranges for perl and posix classes.
+    local classChar = function(pos) (
+      local c = at(pos);
+      if c == null then fail(pattern, 'missing closing ]')
+      else if c == '\\' then (
+        local e = parseEscape(pos + 1, true);
+        local single = std.length(e.ranges) == 1 && e.ranges[0][0] == e.ranges[0][1];
+        if single then { c: e.ranges[0][0], pos: e.pos } else { ranges: e.ranges, pos: e.pos }
+      )
+      else if c == '[' && at(pos + 1) == ':' then (
+        local end = std.findSubstr(':]', pattern[pos + 2:]);
+        local name = if std.length(end) == 0 then '' else pattern[pos + 2:pos + 2 + end[0]];
+        if std.objectHas(posixClasses, name) then { ranges: posixClasses[name], pos: pos + 4 + end[0] }
+        else if std.length(end) > 0 && std.startsWith(name, '^') && std.objectHas(posixClasses, name[1:])
+        then { ranges: complement(posixClasses[name[1:]]), pos: pos + 4 + end[0] }
+        else { c: cp(c), pos: pos + 1 }
+      )
+      else { c: cp(c), pos: pos + 1 }
+    );
+    local loop = function(pos, ranges, first) (
+      local c = at(pos);
+      if c == null then fail(pattern, 'missing closing ]')
+      else if c == ']' && !first then { node: classNode(ranges, neg, flags), pos: pos + 1 }
+      else (
+        local lo = classChar(pos);
+        if std.objectHas(lo, 'ranges') then loop(lo.pos, ranges + lo.ranges, false) tailstrict
+        else if at(lo.pos) == '-' && at(lo.pos + 1) != ']' && at(lo.pos + 1) != null then (
+          local hi = classChar(lo.pos + 1);
+          if std.objectHas(hi, 'ranges') || hi.c < lo.c then fail(pattern, 'invalid character class range')
+          else loop(hi.pos, ranges + [[lo.c, hi.c]], false) tailstrict
+        )
+        else loop(lo.pos, ranges + [[lo.c, lo.c]], false) tailstrict
+      )
+    );
+    loop(start, [], true)
+  );
+
+  // parses flags starting after (? returning the new flags and whether a group follows.
+  local parseFlags = function(pos0, flags) (
+    local loop = function(pos, flags, set) (
+      local c = at(pos);
+      if c == ')' then { flags: flags, group: false, pos: pos + 1 }
+      else if c == ':' then { flags: flags, group: true, pos: pos + 1 }
+      else if c == '-' && set then loop(pos + 1, flags, false) tailstrict
+      else if std.member(['i', 'm', 's', 'U'], c) then loop(pos + 1, flags { [c]: set }, set) tailstrict
+      else fail(pattern, 'invalid or unsupported group flags')
+    );
+    loop(pos0, flags, true)
+  );
+
+  // parses a repetition suffix at pos, returning null if there is none.
+  local parseRepeat = function(pos) (
+    local c = at(pos);
+    local lazy = function(r) if at(r.pos) == '?' then r { pos: r.pos + 1 } else r;
+    if c == '*' then lazy({ min: 0, max: -1, pos: pos + 1 })
+    else if c == '+' then lazy({ min: 1, max: -1, pos: pos + 1 })
+    else if c == '?' then lazy({ min: 0, max: 1, pos: pos + 1 })
+    else if c == '{' then (
+      local end = std.findSubstr('}', pattern[pos + 1:]);
+      local body = if std.length(end) == 0 then '' else pattern[pos + 1:pos + 1 + end[0]];
+      local parts = std.split(body, ',');
+      local isNum = function(s) std.length(s) > 0 && std.length(std.filter(function(d) !inRanges(digitRanges, cp(d)), std.stringChars(s))) == 0;
+      local valid = std.length(parts) <= 2 && isNum(parts[0]) && (std.length(parts) == 1 || parts[1] == '' || isNum(parts[1]));
+      if !valid then null
+      else (
+        local min = std.parseInt(parts[0]);
+        local max = if std.length(parts) == 1 then min else if parts[1] == '' then -1 else std.parseInt(parts[1]);
+        if min > 1000 || max > 1000 then fail(pattern, 'invalid repeat count')
+        else if max != -1 && max < min then fail(pattern, 'invalid repeat count')
+        else lazy({ min: min, max: max, pos: pos + 2 + end[0] })
+      )
+    )
+    else null
+  );
+
+  local isRepeat = function(pos) std.member(['*', '+', '?'], at(pos)) || (at(pos) == '{' && parseRepeat(pos) != null);
+
+  // parses an alternation until the end of pattern or a closing parenthesis
+  local parseAlt = function(pos0, flags0) (
+    // parses a single atom, returning a node or new flags for flag-only groups
+    local parseAtom = function(pos, flags) (
+      local c = at(pos);
+      local s = std.objectHas(flags, 's') && flags.s;
+      local m = std.objectHas(flags, 'm') && flags.m;
+      if c == '(' then (
+        local group = function(pos, flags) (
+          local inner = parseAlt(pos, flags);
+          if at(inner.pos) != ')' then fail(pattern, 'missing closing )') else { node: inner.node, pos: inner.pos + 1 }
+        );
+        if at(pos + 1) != '?' then group(pos + 1, flags)
+        else if at(pos + 2) == 'P' || (at(pos + 2) == '<' && at(pos + 3) != '=' && at(pos + 3) != '!') then (
+          local nameStart = if at(pos + 2) == 'P' then pos + 3 else pos + 2;
+          local end = std.findSubstr('>', pattern[nameStart:]);
+          if at(nameStart) != '<' || std.length(end) == 0 then fail(pattern, 'invalid named capture')
+          else group(nameStart + end[0] + 1, flags)
+        )
+        else (
+          local f = parseFlags(pos + 2, flags);
+          if f.group then group(f.pos, f.flags) else { flags: f.flags, pos: f.pos }
+        )
+      )
+      else if c == '[' then parseClass(pos + 1, flags)
+      else if c == '.' then { node: classNode(if s then [] else [[10, 10]], true), pos: pos + 1 }
+      else if c == '^' then { node: { t: 'assert', kind: if m then 'bol' else 'bot' }, pos: pos + 1 }
+      else if c == '$' then { node: { t: 'assert', kind: if m then 'eol' else 'eot' }, pos: pos + 1 }
+      else if c == '\\' then (
+        local e = parseEscape(pos + 1, false);
+        if std.objectHas(e, 'assertion') then { node: { t: 'assert', kind: e.assertion }, pos: e.pos }
+        else { node: classNode(e.ranges, false, flags), pos: e.pos }
+      )
+      else if isRepeat(pos) then fail(pattern, 'missing argument to repetition operator')
+      else { node: literal(cp(c), flags), pos: pos + 1 }
+    );
+
+    // parses a concatenation
+    local parseSeq = function(pos, flags, items) (
+      local c = at(pos);
+      if c == null || c == '|' || c == ')' then { node: { t: 'seq', items: items }, pos: pos, flags: flags }
+      else (
+        local atom = parseAtom(pos, flags);
+        if std.objectHas(atom, 'flags') then parseSeq(atom.pos, atom.flags, items) tailstrict
+        else (
+          local rep = parseRepeat(atom.pos);
+          if rep == null then parseSeq(atom.pos, flags, items + [atom.node]) tailstrict
+          else if isRepeat(rep.pos) then fail(pattern, 'invalid nested repetition operator')
+          else if atom.node.t == 'assert' then parseSeq(rep.pos, flags, items + [atom.node]) tailstrict
+          else parseSeq(rep.pos, flags, items + [{ t: 'rep', node: atom.node, min: rep.min, max: rep.max }]) tailstrict
+        )
+      )
+    );
+
+    local loop = function(pos, flags, alts) (
+      local seq = parseSeq(pos, flags, []);
+      if at(seq.pos) == '|' then loop(seq.pos + 1, seq.flags, alts + [seq.node]) tailstrict
+      else { node: if std.length(alts) == 0 then seq.node else { t: 'alt', alts: alts + [seq.node] }, pos: seq.pos }
+    );
+    loop(pos0, flags0, [])
+  );
+
+  local result = parseAlt(0, {});
+  if result.pos != n then fail(pattern, 'unexpected )') else result.node
+);
+
+// compiler. Turns a node into a list of instructions starting at the supplied program counter.
+local compile = function(node, pc) (
+  local compileNode = function(node, pc) (
+    if node.t == 'class' then [{ op: 'class', neg: node.neg, ranges: node.ranges }]
+    else if node.t == 'assert' then [{ op: 'assert', kind: node.kind }]
+    else if node.t == 'seq' then std.foldl(function(prev, item) prev + compileNode(item, pc + std.length(prev)), node.items, [])
+    else if node.t == 'alt' then (
+      // split L1, L2; L1: alt1; jmp end; L2: split ... ; last alternative has no split
+      local sizes = std.map(function(a) std.length(compileNode(a, 0)), node.alts);
+      local count = std.length(node.alts);
+      local total = std.foldl(function(prev, s) prev + s, sizes, 0) + 2 * (count - 1);
+      local end = pc + total;
+      std.foldl(
+        function(prev, i) (
+          local start = pc + std.length(prev);
+          if i == count - 1 then prev + compileNode(node.alts[i], start)
+          else (
+            local code = compileNode(node.alts[i], start + 1);
+            prev + [{ op: 'split', x: start + 1, y: start + 2 + std.length(code) }] + code + [{ op: 'jmp', x: end }]
+          )
+        ),
+        std.range(0, count - 1),
+        []
+      )
+    )
+    else if node.t == 'rep' then (
+      local size = std.length(compileNode(node.node, 0));
+      local required = std.foldl(function(prev, i) prev + compileNode(node.node, pc + std.length(prev)), std.range(1, node.min), []);
+      local start = pc + std.length(required);
+      if node.max == -1 then (
+        // L: split L+1, end; node; jmp L
+        required + [{ op: 'split', x: start + 1, y: start + size + 2 }] + compileNode(node.node, start + 1) + [{ op: 'jmp', x: start }]
+      ) else (
+        local optional = node.max - node.min;
+        local end = start + optional * (size + 1);
+        required + std.foldl(
+          function(prev, i) (
+            local at = start + std.length(prev);
+            prev + [{ op: 'split', x: at + 1, y: end }] + compileNode(node.node, at + 1)
+          ),
+          std.range(1, optional),
+          []
+        )
+      )
+    )
+    else error 'regex: internal error, unknown node type %s' % node.t
+  );
+  compileNode(node, pc) + [{ op: 'match' }]
+);
+
+// returns true if the compiled program matches anywhere in the input string.
+local run = function(prog, input) (
+  local cps = std.map(std.codepoint, std.stringChars(input));
+  local n = std.length(cps);
+  local charAt = function(i) if i >= 0 && i < n then cps[i] else null;
+
+  local assertHolds = function(kind, i) (
+    if kind == 'bot' then i == 0
+    else if kind == 'eot' then i == n
+    else if kind == 'bol' then i == 0 || charAt(i - 1) == 10
+    else if kind == 'eol' then i == n || charAt(i) == 10
+    else if kind == 'wordb' then isWordChar(charAt(i - 1)) != isWordChar(charAt(i))
+    else if kind == 'nwordb' then isWordChar(charAt(i - 1)) == isWordChar(charAt(i))
+    else error 'regex: internal error, unknown assertion %s' % kind
+  );
+
+  // follows all empty transitions from the supplied program counters at position i, returning the
+  // program counters of instructions that consume input or match.
+  local closure = function(pcs, i) (
+    local loop = function(stack, seen, out) (
+      if std.length(stack) == 0 then out
+      else (
+        local pc = stack[std.length(stack) - 1];
+        local rest = stack[0:std.length(stack) - 1];
+        local key = std.toString(pc);
+        if std.objectHas(seen, key) then loop(rest, seen, out) tailstrict
+        else (
+          local inst = prog[pc];
+          local seen2 = seen { [key]: true };
+          if inst.op == 'jmp' then loop(rest + [inst.x], seen2, out) tailstrict
+          else if inst.op == 'split' then loop(rest + [inst.y, inst.x], seen2, out) tailstrict
+          else if inst.op == 'assert' then (
+            if assertHolds(inst.kind, i) then loop(rest + [pc + 1], seen2, out) tailstrict
+            else loop(rest, seen2, out) tailstrict
+          )
+          else loop(rest, seen2, out + [pc]) tailstrict
+        )
+      )
+    );
+    loop(pcs, {}, [])
+  );
+
+  local step = function(i, pcs) (
+    local threads = closure(pcs + [0], i);
+    if std.length(std.filter(function(pc) prog[pc].op == 'match', threads)) > 0 then true
+    else if i == n then false
+    else (
+      local c = cps[i];
+      local next = std.set([
+        pc + 1
+        for pc in threads
+        if prog[pc].op == 'class' && inRanges(prog[pc].ranges, c) != prog[pc].neg
+      ]);
+      step(i + 1, next) tailstrict
+    )
+  );
+  step(0, [])
+);
+
+{
+  // compile returns the program for the supplied pattern, failing if the pattern is invalid or unsupported.
+  compile(pattern):: compile(parse(pattern), 0),
+
+  // match returns true if the pattern matches anywhere in the input.
+  match(pattern, input):: run(self.compile(pattern), input),
+}
@@ -0,0 +1,184 @@
+// Functions for google.protobuf.Timestamp and google.protobuf.Duration values. Times are represented as objects with
+// seconds and nanos fields, matching the JSON encoding of the messages in validation rules. Seconds and nanos are
+// kept separate to avoid losing precision in floating point numbers.
+local minSeconds = -62135596800;  // 0001-01-01T00:00:00Z
+local maxSeconds = 253402300799;  // 9999-12-31T23:59:59Z
+local nanosPerSecond = 1000000000;
+
+local div = function(a, b) std.floor(a / b);
+local mod = function(a, b) a - b * div(a, b);
+
+// returns the seconds and nanos of a time, allowing either of them to be missing.
+local seconds = function(t) if std.objectHas(t, 'seconds') then t.seconds else 0;
+local nanos = function(t) if std.objectHas(t, 'nanos') then t.nanos else 0;
+
+// returns -1, 0 or 1 if a is less than, equal to or greater than b.
+local compare = function(a, b) (
+  local sa = seconds(a);
+  local sb = seconds(b);
+  local na = nanos(a);
+  local nb = nanos(b);
+  if sa < sb || (sa == sb && na < nb) then -1
+  else if sa == sb && na == nb then 0
+  else 1
+);
+
+// returns a normalized time from seconds and nanos that may be out of range or have different signs.
+local normalize = function(s, n) { seconds: s + div(n, nanosPerSecond), nanos: mod(n, nanosPerSecond) };
+
+local add = function(a, b) normalize(seconds(a) + seconds(b), nanos(a) + nanos(b));
+local subtract = function(a, b) normalize(seconds(a) - seconds(b), nanos(a) - nanos(b));
+local negate = function(a) normalize(-seconds(a), -nanos(a));
+
+// day calculations for the proleptic Gregorian calendar from http://howardhinnant.github.io/date_algorithms.html
+local daysFromCivil = function(y0, m, d) (
+  local y = if m <= 2 then y0 - 1 else y0;
+  local era = div(y, 400);
+  local yoe = y - era * 400;
+  local doy = div(153 * (if m > 2 then m - 3 else m + 9) + 2, 5) + d - 1;
+  local doe = yoe * 365 + div(yoe, 4) - div(yoe, 100) + doy;
+  era * 146097 + doe - 719468
+);
+
+local civilFromDays = function(z0) (
+  local z = z0 + 719468;
+  local era = div(z, 146097);
+  local doe = z - era * 146097;
+  local yoe = div(doe - div(doe, 1460) + div(doe, 36524) - div(doe, 146096), 365);
+  local doy = doe - (365 * yoe + div(yoe, 4) - div(yoe, 100));
+  local mp = div(5 * doy + 2, 153);
+  local m = if mp < 10 then mp + 3 else mp - 9;
+  { year: yoe + era * 400 + (if m <= 2 then 1 else 0), month: m, day: doy - div(153 * mp + 2, 5) + 1 }
+);
+
+local isLeapYear = function(y) (y % 4 == 0 && y % 100 != 0) || y % 400 == 0;
+local daysInMonth = function(y, m) if m == 2 then (if isLeapYear(y) then 29 else 28) else [31, 0, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31][m - 1];
+
+// returns the nanos for a string of fractional digits, ignoring digits after the ninth.
+local fractionNanos = function(digits) (
+  local d = if std.length(digits) > 9 then digits[0:9] else digits;
+  std.parseInt(d + std.join('', std.makeArray(9 - std.length(d), function(i) '0')))
+);
+
+// returns fractional digits for nanos, using 0, 3, 6 or 9 digits as produced by the proto3 JSON mapping.
+local fractionDigits = function(n) (
+  local s = '%09d' % n;
+  if n == 0 then ''
+  else if n % 1000000 == 0 then '.' + s[0:3]
+  else if n % 1000 == 0 then '.' + s[0:6]
+  else '.' + s
+);
+
+local isDigit = function(c) std.codepoint(c) >= 48 && std.codepoint(c) <= 57;
+local allDigits = function(s) std.length(std.filter(function(c) !isDigit(c), std.stringChars(s))) == 0;
+
+// returns true if the string has the layout of a timestamp: 1972-01-01T10:00:20.021Z, with optional fractional
+// digits and a zone that is either Z or an offset like +01:00.
+local timestampLayout = function(s) (
+  local n = std.length(s);
+  local zone = if n > 0 && s[n - 1] == 'Z' then n - 1 else n - 6;
+  n >= 20 && zone >= 19 &&
+  allDigits(s[0:4]) && s[4] == '-' && allDigits(s[5:7]) && s[7] == '-' && allDigits(s[8:10]) && s[10] == 'T' &&
+  allDigits(s[11:13]) && s[13] == ':' && allDigits(s[14:16]) && s[16] == ':' && allDigits(s[17:19]) &&
+  (zone == 19 || (s[19] == '.' && zone > 20 && allDigits(s[20:zone]))) &&
+  (s[zone] == 'Z' || ((s[zone] == '+' || s[zone] == '-') && allDigits(s[zone + 1:zone + 3]) && s[zone + 3] == ':' && allDigits(s[zone + 4:n])))
+);
+
+// parses an RFC 3339 timestamp as used by the proto3 JSON mapping, returning null if it is invalid or out of range.
+// Like the Go implementation, offsets up to 24:60 are allowed and fractional digits after the ninth are truncated.
+local parseTimestamp = function(s) (
+  if !timestampLayout(s) then null else (
+    local num = function(start, end) std.parseInt(s[start:end]);
+    local year = num(0, 4);
+    local month = num(5, 7);
+    local day = num(8, 10);
+    local hour = num(11, 13);
+    local minute = num(14, 16);
+    local second = num(17, 19);
+    local zone = std.length(s) - (if std.endsWith(s, 'Z') then 1 else 6);
+    local frac = if s[19] == '.' then s[20:zone] else '';
+    local offsetHour = if s[zone] == 'Z' then 0 else num(zone + 1, zone + 3);
+    local offsetMinute = if s[zone] == 'Z' then 0 else num(zone + 4, zone + 6);
+    local offsetSign = if s[zone] == '-' then -1 else 1;
+    local valid = month >= 1 && month <= 12 && day >= 1 && day <= daysInMonth(year, month) &&
+                  hour <= 23 && minute <= 59 && second <= 59 && offsetHour <= 24 && offsetMinute <= 60;
+    local secs = daysFromCivil(year, month, day) * 86400 + hour * 3600 + minute * 60 + second -
+                 offsetSign * (offsetHour * 3600 + offsetMinute * 60);
+    if !valid || secs < minSeconds || secs > maxSeconds then null
+    else { seconds: secs, nanos: if frac == '' then 0 else fractionNanos(frac) }
+  )
+);
+
+local maxDurationSeconds = 315576000000;
+
+// returns true if the duration is in the range allowed by google.protobuf.Duration and its seconds and nanos do not
+// have different signs.
+local validDuration = function(d) (
+  local s = seconds(d);
+  local n = nanos(d);
+  std.abs(s) <= maxDurationSeconds && std.abs(n) < nanosPerSecond && !(s < 0 && n > 0) && !(s > 0 && n < 0)
+);
+
+// parses a duration string like 1.5s as used by the proto3 JSON mapping, returning null if it is invalid or out of
+// range. Like the Go implementation, a leading plus sign is allowed and the integer and fractional digits may both be
+// empty when a decimal point is present. The seconds and nanos of the result have the same sign.
+local parseDuration = function(s) (
+  local neg = std.startsWith(s, '-');
+  local body = if neg || std.startsWith(s, '+') then s[1:] else s;
+  local n = std.length(body);
+  local dot = std.findSubstr('.', body);
+  local intPart = if std.length(dot) == 0 then body[0:n - 1] else body[0:dot[0]];
+  local fracPart = if std.length(dot) == 0 then '' else body[dot[0] + 1:n - 1];
+  local valid = n >= 2 && body[n - 1] == 's' && std.length(dot) <= 1 &&
+                allDigits(intPart) && allDigits(fracPart) && std.length(fracPart) <= 9 &&
+                (std.length(intPart) <= 1 || intPart[0] != '0');
+  local sign = if neg then -1 else 1;
+  local d = if !valid then null else {
+    seconds: sign * (if intPart == '' then 0 else std.parseInt(intPart)),
+    nanos: sign * (if fracPart == '' then 0 else fractionNanos(fracPart)),
+  };
+  if d == null || !validDuration(d) then null else d
+);
+
+// returns the duration for a string like 1.5s or an object with seconds and nanos that may be numeric strings, as
+// allowed for the fields of google.protobuf.Duration messages in rules. Returns null for an invalid string.
+local durationValue = function(v) (
+  local num = function(n) if std.type(n) == 'string' then std.parseJson(n) else n;
+  if std.type(v) == 'string' then parseDuration(v) else { seconds: num(seconds(v)), nanos: num(nanos(v)) }
+);
+
+// formats a timestamp in UTC as produced by the proto3 JSON mapping.
+local formatTimestamp = function(t) (
+  local s = seconds(t);
+  local date = civilFromDays(div(s, 86400));
+  local daySeconds = mod(s, 86400);
+  '%04d-%02d-%02dT%02d:%02d:%02d%sZ' % [
+    date.year,
+    date.month,
+    date.day,
+    div(daySeconds, 3600),
+    div(mod(daySeconds, 3600), 60),
+    mod(daySeconds, 60),
+    fractionDigits(nanos(t)),
+  ]
+);
+
+// formats a duration as produced by the proto3 JSON mapping.
+local formatDuration = function(d) (
+  local neg = compare(d, {}) < 0;
+  local abs = if neg then negate(d) else normalize(seconds(d), nanos(d));
+  '%s%d%ss' % [if neg then '-' else '', abs.seconds, fractionDigits(abs.nanos)]
+);
+
+{
+  compare:: compare,
+  add:: add,
+  subtract:: subtract,
+  negate:: negate,
+  validDuration:: validDuration,
+  parseDuration:: parseDuration,
+  durationValue:: durationValue,
+  parseTimestamp:: parseTimestamp,
+  formatTimestamp:: formatTimestamp,
+  formatDuration:: formatDuration,
+}
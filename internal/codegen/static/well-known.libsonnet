@@ -1,4 +1,7 @@
 local dispatch = import 'dispatch.libsonnet';
+local formats = import 'formats.libsonnet';
+local options = import 'options.libsonnet';
+local time = import 'time.libsonnet';
 local validate = dispatch();
 local normalize = dispatch('normalizer', false);
 local isValue = function(input) std.type(input) == 'object' && std.objectHas(input, 'value') && std.length(input) == 1;
@@ -14,67 +17,71 @@ local check = function(t, fn) (
 local isString = function(input) std.type(input) == 'string';
 local isStringOrValue = function(input) isString(input) || (isValue(input) && isString(input.value));
 
+// bytes are strings with standard or URL-safe base64 encoding
+local checkBase64 = function(t, fn) (
+  local typeCheck = check(t, fn);
+  function(input, ctx='') (
+    local v = typeCheck(input, ctx);
+    local s = if isValue(v) then v.value else v;
+    if formats.base64Decode(s) != null then v else error '%s: invalid base64 input "%s" for type %s' % [ctx, s, t]
+  )
+);
+
 local stringTable = {
   string: { validator: check('string', isString) },
   'google.protobuf.StringValue': { validator: check('google.protobuf.StringValue', isStringOrValue) },
-  bytes: { validator: check('bytes', isString) },
-  'google.protobuf.BytesValue': { validator: check('google.protobuf.BytesValue', isStringOrValue) },
+  bytes: { validator: checkBase64('bytes', isString) },
+  'google.protobuf.BytesValue': { validator: checkBase64('google.protobuf.BytesValue', isStringOrValue) },
 };
 
-// integer types
-local min32 = -2147483648;
-local max32 = 2147483648;
-local min64 = -9223372036854775808;
-local max64 = 9223372036854775808;
+// integer types. Bounds are digit strings so that 64-bit values specified as strings can be checked without losing
+// precision in floating point numbers.
+local bounds32 = { min: '-2147483648', max: '2147483647' };
+local boundsU32 = { min: '0', max: '4294967295' };
+local bounds64 = { min: '-9223372036854775808', max: '9223372036854775807' };
+local boundsU64 = { min: '0', max: '18446744073709551615' };
 
 local wellKnownInts = {
-  int32: {
-    min: min32,
-    max: max32,
-    wrapper: false,
-  },
+  int32: bounds32 { wrapper: false },
   'google.protobuf.Int32Value': $.int32 { wrapper: true },
   sint32: $.int32,
-  fixed32: $.int32,
   sfixed32: $.int32,
 
-  int64: $.int32 {
-    min: min64,
-    max: max64,
-  },
+  int64: bounds64 { wrapper: false },
   'google.protobuf.Int64Value': $.int64 { wrapper: true },
   sint64: $.int64,
-  fixed64: $.int64,
   sfixed64: $.int64,
 
-  uint32: $.int32 { min: 0 },
+  uint32: boundsU32 { wrapper: false },
   'google.protobuf.UInt32Value': $.uint32 { wrapper: true },
+  fixed32: $.uint32,
 
-  uint64: $.int64 { min: 0 },
+  uint64: boundsU64 { wrapper: false },
   'google.protobuf.UInt64Value': $.uint64 { wrapper: true },
+  fixed64: $.uint64,
 };
 
+// checks that an integer is in range for its type. Strings are compared digit by digit. Numbers are compared as
+// floating point values, using max + 1 as an exclusive upper bound since it is a power of two that can be represented
+// exactly, unlike the maximum of 64-bit types.
 local validateInteger0 = function(type, input, ctx) (
   local meta = wellKnownInts[type];
-  local v0 = if meta.wrapper && isValue(input) then validateInteger0(type, input.value, ctx) else input;
-  local v1 = if std.type(v0) == 'string' then std.parseInt(v0) else v0;
-  local v2 = if std.type(v1) != 'number'
-  then
-    error '%s: invalid input %s (type=%s)' % [ctx, std.toString(v1), std.type(v1)]
-  else (
-    local v3 = if v1 < meta.min
-    then
-      error '%s: bad value %d (type %s, less that implicit min %d)' % [ctx, v1, type, meta.min]
-    else
-      v1;
-    local v4 = if v3 > meta.max
-    then
-      error '%s: bad value %d (type %s, greater that implicit max %d)' % [ctx, v3, type, meta.min]
-    else
-      v3;
-    v4
-  );
-  v2
+  local v = if meta.wrapper && isValue(input) then validateInteger0(type, input.value, ctx) else input;
+  local t = std.type(v);
+  local badValue = function(reason) error '%s: bad value %s (type %s, %s)' % [ctx, v, type, reason];
+  if t == 'string' then (
+    if !formats.isIntegerString(v) then error '%s: invalid input "%s" (type=string) for type %s, want integer' % [ctx, v, type]
+    else if formats.compareIntegerStrings(v, meta.min) < 0 then badValue('less that implicit min %s' % meta.min)
+    else if formats.compareIntegerStrings(v, meta.max) > 0 then badValue('greater that implicit max %s' % meta.max)
+    else v
+  )
+  else if t == 'number' then (
+    if std.floor(v) != v then badValue('want integer')
+    else if v < std.parseJson(meta.min) then badValue('less that implicit min %s' % meta.min)
+    else if v >= std.parseJson(meta.max) + 1 then badValue('greater that implicit max %s' % meta.max)
+    else v
+  )
+  else error '%s: invalid input %s (type=%s)' % [ctx, std.toString(v), t]
 );
 
 local validateInteger = function(type, input, ctx) std.foldl(
@@ -90,17 +97,55 @@ local intTable = std.foldl(function(prev, type) prev {
   [type]: { validator: function(input, ctx) validateInteger(type, input, ctx) },
 }, std.objectFields(wellKnownInts), {});
 
-// floating point
-local isNumber = function(input) std.type(input) == 'number' || isString(input);  // JSON spec allows string
-local isNumberOrValue = function(input) isNumber(input) || (isValue(input) && isNumber(input.value));
+// floating point numbers may be specified as numbers, as strings with a JSON number literal or as one of the special
+// values NaN, Infinity and -Infinity.
+local specialFloats = ['NaN', 'Infinity', '-Infinity'];
+
+// the smallest magnitude that rounds to infinity as a float32, half way between the largest float32 and the next
+// power of two.
+local float32Limit = std.pow(2, 128) - std.pow(2, 103);
 
-local floatTable = {
-  double: { validator: check('double', isNumber) },
-  float: { validator: check('float', isNumber) },
-  'google.protobuf.FloatValue': { validator: check('google.protobuf.FloatValue', isNumberOrValue) },
-  'google.protobuf.DoubleValue': { validator: check('google.protobuf.DoubleValue', isNumberOrValue) },
+local floatTypes = {
+  double: { float32: false, wrapper: false },
+  float: { float32: true, wrapper: false },
+  'google.protobuf.DoubleValue': $.double { wrapper: true },
+  'google.protobuf.FloatValue': $.float { wrapper: true },
 };
 
+// returns the number for a valid numeric string or number, the string for a special value or null otherwise.
+local floatValue = function(v) (
+  if std.type(v) == 'number' then v
+  else if !isString(v) then null
+  else if std.member(specialFloats, v) then v
+  else formats.parseNumber(v)
+);
+
+local validateFloat = function(type) function(input, ctx='') (
+  local meta = floatTypes[type];
+  local v = if meta.wrapper && isValue(input) then input.value else input;
+  local n = floatValue(v);
+  if n == null then (
+    if isString(v) then error '%s: invalid input "%s" (type=string) for type %s, want number, "NaN", "Infinity" or "-Infinity"' % [ctx, v, type]
+    else error '%s: invalid input %s (type=%s) for type %s' % [ctx, std.toString(input), std.type(input), type]
+  )
+  else if meta.float32 && std.type(n) == 'number' && std.abs(n) >= float32Limit then
+    error '%s: bad value %s (type %s, out of range for float)' % [ctx, std.toString(v), type]
+  else input
+);
+
+// converts numeric strings to numbers when the normalizeNumericStrings option is set. Special values remain strings
+// since they cannot be represented as jsonnet numbers.
+local normalizeFloat = function(type) function(input, ctx='') (
+  local v = validateFloat(type)(input, ctx);
+  local n = floatValue(if isValue(v) then v.value else v);
+  local out = if options.normalizeNumericStrings && std.type(n) == 'number' then n else if isValue(v) then v.value else v;
+  if floatTypes[type].wrapper && isValue(v) then { value: out } else out
+);
+
+local floatTable = std.foldl(function(prev, type) prev {
+  [type]: { validator: validateFloat(type), normalizer: normalizeFloat(type) },
+}, std.objectFields(floatTypes), {});
+
 // bool
 local isBool = function(input) std.type(input) == 'boolean';
 local isBoolOrValue = function(input) isBool(input) || (isValue(input) && isBool(input.value));
@@ -116,28 +161,55 @@ local withoutAtType = function(object) (
   std.foldl(function(prev, key) if key == '@type' then prev else prev { [key]: object[key] }, keys, {})
 );
 
-local processAny = function(fn, updateContext=true) function(input, ctx='') (
+// well-known types with a special JSON mapping are embedded in Any values as a value field next to @type.
+local anyValueTypes = [
+  'google.protobuf.Any',
+  'google.protobuf.BoolValue',
+  'google.protobuf.BytesValue',
+  'google.protobuf.DoubleValue',
+  'google.protobuf.Duration',
+  'google.protobuf.Empty',
+  'google.protobuf.FieldMask',
+  'google.protobuf.FloatValue',
+  'google.protobuf.Int32Value',
+  'google.protobuf.Int64Value',
+  'google.protobuf.ListValue',
+  'google.protobuf.StringValue',
+  'google.protobuf.Struct',
+  'google.protobuf.Timestamp',
+  'google.protobuf.UInt32Value',
+  'google.protobuf.UInt64Value',
+  'google.protobuf.Value',
+];
+
+// processes an Any value with the supplied function for the type named by the last segment of its @type URL. In
+// strict mode, a missing or unresolvable @type is an error, except for an empty object representing an empty Any.
+local processAny = function(fn, updateContext=true, strict=false) function(input, ctx='') (
   local obj0 = if std.type(input) == 'object' then input else error '%s: Any field was not an object, got %s' % [ctx, std.type(input)];
-  if !std.objectHas(obj0, '@type') then obj0 else (
+  if !std.objectHas(obj0, '@type') then (
+    if strict && std.length(obj0) > 0 then error '%s: Any @type attribute: want type URL, got none' % ctx else obj0
+  ) else (
     local atType = obj0['@type'];
+    local slashes = if std.type(atType) == 'string' then std.findSubstr('/', atType) else [];
     if std.type(atType) != 'string' then error '%s: Any @type attribute: want string, got %s' % [ctx, std.type(atType)]
+    else if std.length(slashes) == 0 then (
+      if strict then error '%s: Any @type attribute: want type URL like type.googleapis.com/pkg.Message, got %s' % [ctx, atType]
+      else std.trace('WARN: %s: not processing unexpected @type %s' % [ctx, atType], obj0)
+    )
     else (
-      local typeSplit = std.splitLimit(atType, '/', 2);
-      if std.length(typeSplit) != 2 then std.trace('WARN: %s: not processing unexpected @type %s' % [ctx, atType], obj0)
-      else (
-        local typeName = typeSplit[1];
-        local validated = fn(
-          typeName,
-          withoutAtType(obj0),
-          if updateContext then '%s(type:%s)' % [ctx, typeName] else ctx,
-        );
-        validated { '@type': atType }  // restore the atType
-      )
+      local typeName = atType[slashes[std.length(slashes) - 1] + 1:];
+      local context = if updateContext then '%s(type:%s)' % [ctx, typeName] else ctx;
+      local rest = withoutAtType(obj0);
+      local validated = if !std.member(anyValueTypes, typeName) then fn(typeName, rest, context)
+      else if std.objectFields(rest) != ['value'] then
+        error '%s: Any with @type %s: want only a value field, got %s' % [ctx, atType, std.toString(std.objectFields(rest))]
+      else { value: fn(typeName, rest.value, context) };
+      validated { '@type': atType }  // restore the atType
     )
   )
 );
 
-local validateAny = processAny(validate);
+local validateAny = if options.strictAny then processAny(dispatch('validator', true, true), true, true) else processAny(validate);
 local normalizeAny = processAny(normalize, false);
 
 // duration
@@ -165,20 +237,75 @@ local durationValueValidator = function(input, ctx='') (
   fieldValidationOutput
 );
 
+// durations are strings like 1.5s or objects with seconds and nanos that have the same sign
 local validateDuration = function(input, ctx='') (
-  if std.type(input) == 'string'
-  then
-    input
-  else
-    durationValueValidator(input, ctx)
+  local fail = function() error '%s: invalid input %s (type=%s) for type google.protobuf.Duration, want string like "1.5s" with up to 9 fractional digits' % [ctx, std.toString(input), std.type(input)];
+  if isString(input) then (if time.parseDuration(input) != null then input else fail())
+  else if std.type(input) == 'object' then (
+    local v = durationValueValidator(input, ctx);
+    if time.validDuration(time.durationValue(v)) then v
+    else error '%s: invalid value %s for type google.protobuf.Duration, want seconds and nanos with the same sign and at most 315576000000 seconds' % [ctx, std.toString(input)]
+  )
+  else fail()
+);
+
+// normalizes durations to strings with 0, 3, 6 or 9 fractional digits.
+local normalizeDuration = function(input, ctx='') time.formatDuration(time.durationValue(validateDuration(input, ctx)));
+
+// timestamp
+local validateTimestamp = function(input, ctx='') (
+  if isString(input) && time.parseTimestamp(input) != null then input
+  else error '%s: invalid input %s (type=%s) for type google.protobuf.Timestamp, want RFC 3339 string like "1972-01-01T10:00:20.021Z"' % [ctx, std.toString(input), std.type(input)]
+);
+
+// normalizes timestamps to UTC with 0, 3, 6 or 9 fractional digits.
+local normalizeTimestamp = function(input, ctx='') time.formatTimestamp(time.parseTimestamp(validateTimestamp(input, ctx)));
+
+// JSON values: Value holds any JSON value, ListValue an array and Struct an object of values. Functions are the only
+// jsonnet values that cannot be represented in JSON.
+local validateJSON = function(input, ctx) (
+  local t = std.type(input);
+  if t == 'object' then { [k]: validateJSON(input[k], '%s.%s' % [ctx, k]) for k in std.objectFields(input) }
+  else if t == 'array' then std.mapWithIndex(function(i, v) validateJSON(v, '%s[%d]' % [ctx, i]), input)
+  else if t == 'function' then error '%s: invalid input (type=function) for type google.protobuf.Value' % ctx
+  else input
 );
 
+local checkJSON = function(t, fn) (
+  local typeCheck = check(t, fn);
+  function(input, ctx='') validateJSON(typeCheck(input, ctx), ctx)
+);
+
+local jsonTable = {
+  'google.protobuf.Struct': { validator: checkJSON('google.protobuf.Struct', function(input) std.type(input) == 'object') },
+  'google.protobuf.ListValue': { validator: checkJSON('google.protobuf.ListValue', function(input) std.type(input) == 'array') },
+  'google.protobuf.Value': { validator: checkJSON('google.protobuf.Value', function(input) true) },
+  // NullValue is an enum that is represented as null, but also accepts its value name and number like other enums
+  'google.protobuf.NullValue': {
+    validator: check('google.protobuf.NullValue', function(input) input == null || input == 'NULL_VALUE' || input == 0),
+    normalizer: function(input, ctx='') null,
+  },
+};
+
+// field masks are strings with comma-separated lowerCamelCase paths
+local validateFieldMask = function(input, ctx='') (
+  local v = check('google.protobuf.FieldMask', isString)(input, ctx);
+  if formats.fieldMaskPaths(v) != null then v
+  else error '%s: invalid input "%s" for type google.protobuf.FieldMask, want comma-separated paths of lowerCamelCase field names like "name,config.maxSize"' % [ctx, v]
+);
+
+// normalizes field masks by removing surrounding whitespace.
+local normalizeFieldMask = function(input, ctx='') std.join(',', formats.fieldMaskPaths(validateFieldMask(input, ctx)));
+
 stringTable +
 intTable +
 floatTable +
 boolTable +
+jsonTable +
 {
-  'google.protobuf.Struct': { validator: check('google.protobuf.Struct', function(input) std.type(input) == 'object') },
+  'google.protobuf.Empty': { validator: check('google.protobuf.Empty', function(input) std.type(input) == 'object' && std.length(input) == 0) },
+  'google.protobuf.FieldMask': { validator: validateFieldMask, normalizer: normalizeFieldMask },
   'google.protobuf.Any': { validator: validateAny, normalizer: normalizeAny },
-  'google.protobuf.Duration': { validator: validateDuration },
+  'google.protobuf.Duration': { validator: validateDuration, normalizer: normalizeDuration },
+  'google.protobuf.Timestamp': { validator: validateTimestamp, normalizer: normalizeTimestamp },
 }
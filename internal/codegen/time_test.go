@@ -0,0 +1,113 @@
+package codegen_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestTimestamps ensures that timestamps are parsed and formatted in the same way as the Go implementation of the
+// proto3 JSON mapping.
+func TestTimestamps(t *testing.T) {
+	inputs := []string{
+		"1970-01-01T00:00:00Z", "1972-01-01T10:00:20.021Z", "2024-02-29T23:59:59.5+05:30", "2023-02-29T00:00:00Z",
+		"1900-02-29T00:00:00Z", "2000-02-29T00:00:00Z", "1969-12-31T23:59:59.999999999Z", "0001-01-01T00:00:00Z",
+		"0001-01-01T00:00:00+01:00", "0001-01-01T00:59:59-01:00", "9999-12-31T23:59:59.999999999Z", "9999-12-31T23:00:00-01:00",
+		"2024-01-01T00:00:00.1234567890Z", "2024-01-01T00:00:00.Z", "2024-01-01T00:00:00", "2024-01-01 00:00:00Z",
+		"2024-01-01t00:00:00z", "2024-13-01T00:00:00Z", "2024-00-10T00:00:00Z", "2024-04-31T00:00:00Z",
+		"2024-01-01T24:00:00Z", "2024-01-01T00:60:00Z", "2024-01-01T00:00:60Z", "2024-01-01T00:00:00+24:00",
+		"2024-01-01T00:00:00+25:00", "2024-01-01T00:00:00-00:60", "2024-01-01T00:00:00-00:61",
+		"2024-01-01T00:00:00+0100", "2024-1-01T00:00:00Z", "", "2024-06-15T12:30:45.000100-07:00",
+	}
+	type testCase struct {
+		Input   string `json:"input"`
+		Seconds *int64 `json:"seconds"`
+		Nanos   int32  `json:"nanos"`
+		Format  string `json:"format"`
+	}
+	var cases []testCase
+	for _, in := range inputs {
+		c := testCase{Input: in}
+		var ts timestamppb.Timestamp
+		if err := protojson.Unmarshal([]byte(strconv.Quote(in)), &ts); err == nil {
+			s := ts.GetSeconds()
+			c.Seconds, c.Nanos = &s, ts.GetNanos()
+			b, err := protojson.Marshal(&ts)
+			require.NoError(t, err)
+			c.Format, err = strconv.Unquote(string(b))
+			require.NoError(t, err)
+		}
+		cases = append(cases, c)
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("time-test", `
+		local time = import 'time.libsonnet';
+		local result = function(c) (
+			local t = time.parseTimestamp(c.input);
+			if t == null then c { seconds: null, nanos: 0, format: '' }
+			else c { seconds: t.seconds, nanos: t.nanos, format: time.formatTimestamp(t) }
+		);
+		function(cases) [{ want: c, got: result(c) } for c in cases if result(c) != c]
+	`)
+	require.NoError(t, err)
+	var failed []interface{}
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
+
+// TestDurations ensures that durations are parsed and formatted in the same way as the Go implementation of the
+// proto3 JSON mapping.
+func TestDurations(t *testing.T) {
+	inputs := []string{
+		"0s", "1s", "-1s", "1.5s", "-1.5s", "0.000000001s", "-0.000000001s", "1.000000000s", "1.0000000001s", ".5s", "5.s",
+		"-.5s", "01s", "00s", "0.5s", "1", "s", "-s", ".s", "1.5", "1.5S", "30 s", " 30s", "1e3s", "+1s", "--1s", "1.2.3s",
+		"315576000000s", "315576000000.999999999s", "315576000001s", "-315576000000.999999999s", "-315576000001s", "",
+		"123456.000100s", "1.100s", "18446744073709551616s", "+.5s", "-.s", "+s", "+-1s",
+	}
+	type testCase struct {
+		Input   string `json:"input"`
+		Seconds *int64 `json:"seconds"`
+		Nanos   int32  `json:"nanos"`
+		Format  string `json:"format"`
+	}
+	var cases []testCase
+	for _, in := range inputs {
+		c := testCase{Input: in}
+		var d durationpb.Duration
+		if err := protojson.Unmarshal([]byte(strconv.Quote(in)), &d); err == nil {
+			s := d.GetSeconds()
+			c.Seconds, c.Nanos = &s, d.GetNanos()
+			b, err := protojson.Marshal(&d)
+			require.NoError(t, err)
+			c.Format, err = strconv.Unquote(string(b))
+			require.NoError(t, err)
+		}
+		cases = append(cases, c)
+	}
+	b, err := json.Marshal(cases)
+	require.NoError(t, err)
+	vm := regexVM()
+	vm.TLACode("cases", string(b))
+	out, err := vm.EvaluateAnonymousSnippet("time-test", `
+		local time = import 'time.libsonnet';
+		local result = function(c) (
+			local d = time.parseDuration(c.input);
+			if d == null then c { seconds: null, nanos: 0, format: '' }
+			else c { seconds: d.seconds, nanos: d.nanos, format: time.formatDuration(d) }
+		);
+		function(cases) [{ want: c, got: result(c) } for c in cases if result(c) != c]
+	`)
+	require.NoError(t, err)
+	var failed []interface{}
+	require.NoError(t, json.Unmarshal([]byte(out), &failed))
+	assert.Empty(t, failed)
+}
@@ -18,7 +18,7 @@ package codegen
 
 import (
 	"bytes"
-	"fmt"
+	"path"
 	"sort"
 	"strings"
 
@@ -78,10 +78,10 @@ func render(root map[string]interface{}) string {
 	return b.String()
 }
 
-func (c *CodeGenerator) makePackageMap() map[string]interface{} {
+func (c *CodeGenerator) makePackageMap(t *tree) map[string]interface{} {
 	root := map[string]interface{}{}
 	pkgs := map[string]bool{}
-	for _, v := range c.TypeMap {
+	for _, v := range t.types {
 		pkgs[v.Package()] = true
 	}
 
@@ -93,23 +93,23 @@ func (c *CodeGenerator) makePackageMap() map[string]interface{} {
 	return root
 }
 
-func (c *CodeGenerator) generateTypes() *pluginpb.CodeGeneratorResponse_File {
-	root := c.makePackageMap()
-	for _, v := range c.TypeMap {
+func (c *CodeGenerator) generateTypes(t *tree) (*pluginpb.CodeGeneratorResponse_File, error) {
+	root := c.makePackageMap(t)
+	for _, v := range t.types {
 		if !v.IsTopLevel() {
 			continue
 		}
 		entry := findPackage(root, v.Package())
-		entry[v.Name()] = fmt.Sprintf("(import 'pkg/%s.libsonnet').definition", filePathForType(v))
+		entry[v.Name()] = c.libraryExpr(c.importPath(t, "", path.Join(pkgPath, c.libraryPath(v))), v) + ".definition"
 	}
 	out := render(root)
 	content, err := formatJsonnet(out, formatter.DefaultOptions())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	return &pluginpb.CodeGeneratorResponse_File{
-		Name:    proto.String(typesFile),
+		Name:    proto.String(c.typesFile()),
 		Content: proto.String(content),
-	}
+	}, nil
 
 }
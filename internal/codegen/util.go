@@ -21,7 +21,8 @@ import (
 	"encoding/json"
 	"fmt"
 	htmlTemplate "html/template"
-	"os"
+	"path"
+	"strings"
 	"text/template"
 
 	"github.com/google/go-jsonnet/formatter"
@@ -30,7 +31,8 @@ import (
 )
 
 const (
-	defaultPackage = "_default"
+	defaultPackage    = "_default"
+	packageBundleName = "package"
 )
 
 // toJSON implements a json encoding function for use in text templates.
@@ -55,10 +57,8 @@ func templateFor(str string) *template.Template {
 	return template.Must(
 		template.New("jsonnet").
 			Funcs(template.FuncMap{
-				"json":            toJSON,
-				"terseJson":       toTerseJSON,
-				"fileNameForType": fileNameForType,
-				"filePathForType": filePathForType,
+				"json":      toJSON,
+				"terseJson": toTerseJSON,
 			}).
 			Parse(str),
 	)
@@ -70,14 +70,13 @@ func htmlTemplateFor(name string, str string) *htmlTemplate.Template {
 	return htmlTemplate.Must(
 		root.New(name).
 			Funcs(htmlTemplate.FuncMap{
-				"json":            toJSON,
-				"terseJson":       toTerseJSON,
-				"fileNameForType": fileNameForType,
-				"filePathForType": filePathForType,
-				"headerValues": func(title, stylesPath string) map[string]interface{} {
+				"json":      toJSON,
+				"terseJson": toTerseJSON,
+				"headerValues": func(title, stylesPath, indexFile string) map[string]interface{} {
 					return map[string]interface{}{
 						"Title":      title,
 						"StylesPath": stylesPath,
+						"IndexFile":  indexFile,
 					}
 				},
 			}).
@@ -88,8 +87,7 @@ func htmlTemplateFor(name string, str string) *htmlTemplate.Template {
 func formatJsonnet(s string, opts formatter.Options) (string, error) {
 	content, err := formatter.Format("generated.jsonnet", s, opts)
 	if err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, s)
-		return "", err
+		return "", fmt.Errorf("invalid generated jsonnet: %w", err)
 	}
 	return content, nil
 }
@@ -105,22 +103,48 @@ func generateJsonnet(t *template.Template, data interface{}) (string, error) {
 	return formatJsonnet(b.String(), formatter.DefaultOptions())
 }
 
-func mustGenerateJsonnet(t *template.Template, data interface{}) string {
-	ret, err := generateJsonnet(t, data)
-	if err != nil {
-		panic(err)
+// fileNameForType returns the name of the files generated for the supplied type, without extension.
+func (c *CodeGenerator) fileNameForType(t model.Type) string {
+	if c.FileNames == FileNamesVerbatim {
+		return t.NestedName()
 	}
-	return ret
+	return strcase.ToKebab(t.NestedName())
 }
 
-func fileNameForType(t model.Type) string {
-	return strcase.ToKebab(t.NestedName())
+// filePathForType returns the path of the files generated for the supplied type relative to the package or
+// documentation directory, without extension.
+func (c *CodeGenerator) filePathForType(t model.Type) string {
+	p := t.Package()
+	if p == "" {
+		p = defaultPackage
+	}
+	return fmt.Sprintf("%s/%s", p, c.fileNameForType(t))
 }
 
-func filePathForType(t model.Type) string {
+// libraryPath returns the path of the library file that defines the supplied type relative to the package directory,
+// without extension. Bundles are placed in the directory of their package, like the files of single types, so that they
+// can import the static files in the same way.
+func (c *CodeGenerator) libraryPath(t model.Type) string {
 	p := t.Package()
 	if p == "" {
 		p = defaultPackage
 	}
-	return fmt.Sprintf("%s/%s", p, fileNameForType(t))
+	switch c.layout() {
+	case LayoutFile:
+		return fmt.Sprintf("%s/%s", p, strings.TrimSuffix(path.Base(t.File()), ".proto"))
+	case LayoutPackage:
+		return fmt.Sprintf("%s/%s", p, packageBundleName)
+	default:
+		return c.filePathForType(t)
+	}
+}
+
+// libraryExpr returns the jsonnet expression for the library object of the supplied type, given the import path of its
+// library file without extension. Bundles are objects keyed by the qualified names of their types.
+func (c *CodeGenerator) libraryExpr(importPath string, t model.Type) string {
+	ret := fmt.Sprintf("(import '%s.libsonnet')", importPath)
+	if c.layout() != LayoutType {
+		ret += fmt.Sprintf("['%s']", t.QualifiedName())
+	}
+	return ret
 }
@@ -17,6 +17,8 @@
 package codegen
 
 import (
+	"path"
+
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/pluginpb"
 )
@@ -25,15 +27,54 @@ import (
 var validatorTemplate = templateFor(`
 {
 	{{- range $k, $v := . }}
-	'{{$v.QualifiedName}}': (import '{{filePathForType $v}}.libsonnet'),
+	'{{$k}}': {{$v}},
 	{{- end }}
 }
 `)
 
-func (c *CodeGenerator) generateValidator() *pluginpb.CodeGeneratorResponse_File {
-	content := mustGenerateJsonnet(validatorTemplate, c.TypeMap)
+// generateValidator generates the validator map for all types in the tree, including referenced types.
+func (c *CodeGenerator) generateValidator(t *tree) (*pluginpb.CodeGeneratorResponse_File, error) {
+	imports := map[string]string{}
+	for k, v := range t.refs {
+		imports[k] = c.libraryExpr(c.refPath(v), v)
+	}
+	for k, v := range t.types {
+		imports[k] = c.libraryExpr(c.importPath(t, pkgPath, path.Join(pkgPath, c.libraryPath(v))), v)
+	}
+	content, err := generateJsonnet(validatorTemplate, imports)
+	if err != nil {
+		return nil, err
+	}
 	return &pluginpb.CodeGeneratorResponse_File{
 		Name:    proto.String(validatorsFile),
 		Content: proto.String(content),
+	}, nil
+}
+
+// optionsTemplate is the code gen template for the file with options used by the static jsonnet files.
+var optionsTemplate = templateFor(`
+// Options set by plugin parameters.
+// Definition generated by protoc-gen-jsonnet. DO NOT EDIT.
+{{ json . }}
+`)
+
+// jsonnetOptions are the options that affect the behavior of generated code.
+type jsonnetOptions struct {
+	StrictAny               bool `json:"strictAny"`
+	NormalizeNumericStrings bool `json:"normalizeNumericStrings"`
+}
+
+// generateOptions generates the options file.
+func (c *CodeGenerator) generateOptions() (*pluginpb.CodeGeneratorResponse_File, error) {
+	content, err := generateJsonnet(optionsTemplate, jsonnetOptions{
+		StrictAny:               c.StrictAny,
+		NormalizeNumericStrings: c.NormalizeNumericStrings,
+	})
+	if err != nil {
+		return nil, err
 	}
+	return &pluginpb.CodeGeneratorResponse_File{
+		Name:    proto.String(optionsFile),
+		Content: proto.String(content),
+	}, nil
 }
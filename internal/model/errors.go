@@ -0,0 +1,89 @@
+/*
+   Copyright 2022 Splunk Inc.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Location is the position of an element in a proto file. Line and column are 1-based and are zero when the
+// descriptor set does not include source code info.
+type Location struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// String returns the location in the file:line:column form understood by editors.
+func (l Location) String() string {
+	if l.Line == 0 {
+		return l.File
+	}
+	return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Column)
+}
+
+// Error is a problem with an element of a proto file, like a message or a field.
+type Error struct {
+	Location Location // where the element is defined
+	Element  string   // the qualified name of the element
+	Err      error    // the underlying problem
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %v", e.Location, e.Element, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// childPath returns the source code info path of a child element, without modifying the parent path.
+func childPath(path []int32, elems ...int32) []int32 {
+	ret := make([]int32, 0, len(path)+len(elems))
+	ret = append(ret, path...)
+	return append(ret, elems...)
+}
+
+// locate returns the location of the element at the supplied source code info path in the file. See the comments of
+// SourceCodeInfo.Location in descriptor.proto for how paths are formed.
+func locate(file *descriptorpb.FileDescriptorProto, path []int32) Location {
+	ret := Location{File: file.GetName()}
+	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+		if !samePath(loc.GetPath(), path) || len(loc.GetSpan()) < 3 {
+			continue
+		}
+		ret.Line, ret.Column = int(loc.GetSpan()[0])+1, int(loc.GetSpan()[1])+1
+		break
+	}
+	return ret
+}
+
+func samePath(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
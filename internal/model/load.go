@@ -16,7 +16,15 @@
 
 package model
 
-import "google.golang.org/protobuf/types/descriptorpb"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/splunk/protobuf-jsonnet/internal/validate"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
 
 // Type is an abstraction over a protobuf message or enum. It exposes the common attributes
 // for each. The GetMessage and GetEnum methods respectively return a non-nil Message or
@@ -24,15 +32,76 @@ import "google.golang.org/protobuf/types/descriptorpb"
 type Type interface {
 	Name() string          // Leaf name for the type
 	Package() string       // Package in which type is declared
+	File() string          // Name of the proto file in which the type is declared
 	IsTopLevel() bool      // returns true if this is a type not nested in a message
 	NestedName() string    // the qualified name of the type not including package name
 	QualifiedName() string // the qualified name of the type including package name
 	GetMessage() *Message  // the underlying message if the type represents a message, or nil
 	GetEnum() *Enum        // the underlying Enum if the type represents an enum, or nil
+	Location() Location    // the position of the type in its proto file
+}
+
+// SortedTypes returns the supplied types ordered by qualified name, for callers that need deterministic output.
+func SortedTypes(types map[string]Type) []Type {
+	ret := make([]Type, 0, len(types))
+	for _, t := range types {
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].QualifiedName() < ret[j].QualifiedName()
+	})
+	return ret
+}
+
+// LoadOptions control how types are loaded.
+type LoadOptions struct {
+	SkipValidations    bool     // ignore all protoc-gen-validate rules
+	ValidationsInclude []string // if not empty, only honor rules for messages and extensions matching one of these names
+	ValidationsExclude []string // ignore rules for messages and extensions matching any of these names
+
+	// FieldMaskTargets maps qualified names of FieldMask fields, such as pkg.UpdateRequest.update_mask, to the qualified
+	// name of the message that their paths refer to.
+	FieldMaskTargets map[string]string
+}
+
+// matchesName returns true if the qualified name is the same as the supplied name or nested under it.
+// Names can therefore be packages, parent packages, messages or parent messages.
+func matchesName(qualifiedName string, names []string) bool {
+	for _, name := range names {
+		if qualifiedName == name || strings.HasPrefix(qualifiedName, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// skipValidations returns true if protoc-gen-validate rules should be ignored for the supplied message or extension.
+func (o LoadOptions) skipValidations(name string) bool {
+	if o.SkipValidations {
+		return true
+	}
+	if len(o.ValidationsInclude) > 0 && !matchesName(name, o.ValidationsInclude) {
+		return true
+	}
+	return matchesName(name, o.ValidationsExclude)
+}
+
+// extension is an extension field along with the qualified name of the message it extends.
+type extension struct {
+	extendee string
+	field    *Field
 }
 
 type loader struct {
-	ret map[string]Type
+	opts       LoadOptions
+	ret        map[string]Type
+	extensions []extension
+	errs       []error
+}
+
+// addError records a problem with an element of a proto file.
+func (c *loader) addError(loc Location, element string, err error) {
+	c.errs = append(c.errs, &Error{Location: loc, Element: element, Err: err})
 }
 
 func (c *loader) registerType(t Type) {
@@ -51,25 +120,28 @@ func (c *loader) addNestedTypes(parent *Message) {
 	}
 }
 
-func (c *loader) getMapType(t Type) (found bool, name string) {
+func (c *loader) getMapType(t Type) (found bool, keyType, valueType string) {
 	msg := t.GetMessage()
 	if msg == nil {
-		return false, ""
+		return false, "", ""
 	}
 	if msg.m.Options == nil || msg.m.Options.MapEntry == nil {
-		return false, ""
+		return false, "", ""
 	}
 	if !msg.m.GetOptions().GetMapEntry() {
-		return false, ""
+		return false, "", ""
 	}
-	// get the field type of the "value" field of the map (for JSON purposes, key is always string)
+	// get the field types of the "key" and "value" fields of the map. For JSON purposes keys are always strings
+	// but the key type is needed to apply validation rules.
 	for _, mapField := range msg.fields {
-		if mapField.Name() != "value" {
-			continue
+		switch mapField.Name() {
+		case "key":
+			keyType = mapField.TypeName()
+		case "value":
+			found, valueType = true, mapField.TypeName()
 		}
-		return true, mapField.TypeName()
 	}
-	return false, ""
+	return found, keyType, valueType
 }
 
 func (c *loader) updateMapTypes() {
@@ -86,30 +158,89 @@ func (c *loader) updateMapTypes() {
 			if !ok {
 				continue
 			}
-			found, valueType := c.getMapType(t)
+			found, keyType, valueType := c.getMapType(t)
 			if found {
 				f.ct = ContainerTypeMap
+				f.keyTypeName = keyType
 				f.typeName = valueType
 			}
 		}
 	}
 }
 
-// Load returns the types found in the specified descriptor set keyed by fully qualified name.
-func Load(ds *descriptorpb.FileDescriptorSet) map[string]Type {
-	l := &loader{ret: map[string]Type{}}
+// addExtension records an extension declared in the supplied scope, a package or a qualified message name, to be
+// added to the message it extends once all types have been loaded. Validation include and exclude lists are matched
+// against the qualified name of the extension, so they apply to the package or message that declares it.
+func (c *loader) addExtension(x *descriptorpb.FieldDescriptorProto, scope string, loc Location) {
+	extendee := strings.TrimPrefix(x.GetExtendee(), ".")
+	name := x.GetName()
+	if scope != "" {
+		name = scope + "." + x.GetName()
+	}
+	var rules *validate.FieldRules
+	if !c.opts.skipValidations(name) {
+		var err error
+		rules, err = getValidationRules(x.GetOptions())
+		if err != nil {
+			c.addError(loc, name, fmt.Errorf("read validate.rules option: %w", err))
+		}
+	}
+	field := newField(x, rules, loc)
+	field.extension = name
+	c.extensions = append(c.extensions, extension{extendee: extendee, field: field})
+}
+
+// updateExtensions adds extension fields to the messages they extend. Extensions of messages that are not in the
+// descriptor set are ignored, and validation rules of extensions are dropped for messages with the validate.disabled
+// option.
+func (c *loader) updateExtensions() {
+	for _, x := range c.extensions {
+		t, ok := c.ret[x.extendee]
+		if !ok || t.GetMessage() == nil {
+			continue
+		}
+		msg := t.GetMessage()
+		if msg.disabled {
+			x.field.rules = nil
+		}
+		msg.fields = append(msg.fields, x.field)
+		msg.sortFields()
+	}
+}
+
+// updateFieldMaskTargets sets the target message for FieldMask fields listed in the load options.
+func (c *loader) updateFieldMaskTargets() {
+	for _, v := range c.ret {
+		msg := v.GetMessage()
+		if msg == nil {
+			continue
+		}
+		for _, f := range msg.fields {
+			f.maskTarget = c.opts.FieldMaskTargets[msg.QualifiedName()+"."+f.Name()]
+		}
+	}
+}
+
+// Load returns the types found in the specified descriptor set keyed by fully qualified name. Problems with
+// individual elements do not stop loading; they are returned together as a joined list of *Error values.
+func Load(ds *descriptorpb.FileDescriptorSet, opts LoadOptions) (map[string]Type, error) {
+	l := &loader{opts: opts, ret: map[string]Type{}}
 	for _, file := range ds.GetFile() {
-		pkg := file.GetPackage()
-		for _, e := range file.GetEnumType() {
-			en := newEnum(pkg, e, nil)
+		for i, e := range file.GetEnumType() {
+			en := newEnum(file, e, nil, []int32{5, int32(i)})
 			l.registerType(en)
 		}
-		for _, msg := range file.GetMessageType() {
-			m := newMessage(pkg, msg, nil)
+		for i, msg := range file.GetMessageType() {
+			m := l.newMessage(file, msg, nil, []int32{4, int32(i)})
 			l.registerType(m)
 			l.addNestedTypes(m)
 		}
+		for i, x := range file.GetExtension() {
+			l.addExtension(x, file.GetPackage(), locate(file, []int32{7, int32(i)}))
+		}
 	}
+	l.updateExtensions()
 	l.updateMapTypes()
-	return l.ret
+	l.updateFieldMaskTargets()
+	return l.ret, errors.Join(l.errs...)
 }
@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -18,7 +19,13 @@ func checkMeta(t *testing.T, msg *Message, expectedFile string) {
 	var expected map[string]FieldMeta
 	err = json.Unmarshal(b, &expected)
 	require.NoError(t, err)
-	assert.EqualValues(t, expected, msg.FieldMeta())
+	// round trip the actual metadata through JSON so that values like defaults have the same Go types
+	b, err = json.Marshal(msg.FieldMeta())
+	require.NoError(t, err)
+	var actual map[string]FieldMeta
+	err = json.Unmarshal(b, &actual)
+	require.NoError(t, err)
+	assert.EqualValues(t, expected, actual)
 }
 
 func fieldsByName(m *Message) map[string]*Field {
@@ -44,7 +51,8 @@ func TestLoad(t *testing.T) {
 		IncludePaths: []string{"testdata"},
 	})
 	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
-	res := Load(ds)
+	res, err := Load(ds, LoadOptions{})
+	require.NoError(t, err)
 	r := require.New(t)
 	a := assert.New(t)
 	a.Equal(7, len(res))
@@ -57,6 +65,7 @@ func TestLoad(t *testing.T) {
 	a.Equal("TopMessage", topMsg.NestedName())
 	a.Equal("testdata.simple.TopMessage", topMsg.QualifiedName())
 	a.Equal("testdata.simple", topMsg.Package())
+	a.Equal("simple/simple.proto", topMsg.File())
 	r.Nil(topMsg.GetEnum())
 	r.NotNil(topMsg.GetMessage())
 	a.Equal(topMsg, topMsg.GetMessage())
@@ -111,6 +120,10 @@ func TestLoad(t *testing.T) {
 	a.EqualValues("testdata.simple.TopMessage.InnerMessage1", f.TypeName())
 	f = fldMap["simple_map"]
 	a.Equal("withSimpleMap", f.SetterName())
+	a.False(f.IsOptional())
+	f = fldMap["label"]
+	a.True(f.IsOptional())
+	a.Equal("", f.OneOfGroup())
 
 	e := res["testdata.simple.TopLevelEnum"].GetEnum()
 	a.EqualValues(map[string]string{"FIRST": "FIRST", "SECOND": "SECOND", "THIRD": "THIRD"}, e.Map())
@@ -131,8 +144,187 @@ func TestValidate(t *testing.T) {
 		IncludePaths: []string{"testdata", ".."},
 	})
 	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
-	res := Load(ds)
+	res, err := Load(ds, LoadOptions{})
+	require.NoError(t, err)
 	topMsg := res["testdata.genvalidate.TopMessage"]
 	dumpMeta(topMsg.GetMessage())
 	checkMeta(t, topMsg.GetMessage(), "testdata/genvalidate/top-message-field-meta.json")
 }
+
+func TestValidateOptions(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"genvalidate/message.proto"},
+		IncludePaths: []string{"testdata", ".."},
+	})
+	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	tests := []struct {
+		name string
+		opts LoadOptions
+		skip bool
+	}{
+		{name: "default", opts: LoadOptions{}},
+		{name: "skip_all", opts: LoadOptions{SkipValidations: true}, skip: true},
+		{name: "include_package", opts: LoadOptions{ValidationsInclude: []string{"testdata.genvalidate"}}},
+		{name: "include_parent_package", opts: LoadOptions{ValidationsInclude: []string{"testdata"}}},
+		{name: "include_other", opts: LoadOptions{ValidationsInclude: []string{"testdata.genvalid"}}, skip: true},
+		{name: "exclude_message", opts: LoadOptions{ValidationsExclude: []string{"testdata.genvalidate.TopMessage"}}, skip: true},
+		{
+			name: "exclude_nested",
+			opts: LoadOptions{
+				ValidationsInclude: []string{"testdata.genvalidate"},
+				ValidationsExclude: []string{"testdata.genvalidate.TopMessage.InnerMessage"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Load(ds, test.opts)
+			require.NoError(t, err)
+			msg := res["testdata.genvalidate.TopMessage"].GetMessage()
+			fldMap := fieldsByName(msg)
+			if test.skip {
+				assert.Nil(t, fldMap["str_field"].ValidationRules())
+				assert.False(t, msg.OneOfs()[0].Required)
+			} else {
+				assert.NotNil(t, fldMap["str_field"].ValidationRules())
+				assert.True(t, msg.OneOfs()[0].Required)
+			}
+		})
+	}
+}
+
+func TestFieldMaskTargets(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"simple/simple.proto"},
+		IncludePaths: []string{"testdata"},
+	})
+	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	res, err := Load(ds, LoadOptions{FieldMaskTargets: map[string]string{
+		"testdata.simple.TopMessage.str_field":          "testdata.simple.TopMessage.InnerMessage1",
+		"testdata.simple.TopMessage.InnerMessage2.stub": "testdata.simple.TopMessage",
+	}})
+	require.NoError(t, err)
+	top := res["testdata.simple.TopMessage"].GetMessage()
+	assert.Equal(t, "testdata.simple.TopMessage.InnerMessage1", top.FieldMeta()["str_field"].MaskTarget)
+	assert.Equal(t, "", top.FieldMeta()["int32_field"].MaskTarget)
+	inner := res["testdata.simple.TopMessage.InnerMessage2"].GetMessage()
+	assert.Equal(t, "testdata.simple.TopMessage", fieldsByName(inner)["stub"].MaskTarget())
+}
+
+func TestProto2(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"proto2/proto2.proto"},
+		IncludePaths: []string{"testdata"},
+	})
+	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	res, err := Load(ds, LoadOptions{})
+	require.NoError(t, err)
+	msg := res["testdata.proto2.Config"].GetMessage()
+	checkMeta(t, msg, "testdata/proto2/config-field-meta.json")
+	fldMap := fieldsByName(msg)
+	assert.True(t, fldMap["name"].IsRequired())
+	assert.False(t, fldMap["retries"].IsRequired())
+	assert.True(t, fldMap["enabled"].HasDefault())
+	assert.False(t, fldMap["plain"].HasDefault())
+	assert.EqualValues(t, "message", fldMap["entry"].FieldType())
+	assert.True(t, fldMap["entry"].IsList())
+	entry := res["testdata.proto2.Config.Entry"].GetMessage()
+	require.NotNil(t, entry)
+	assert.True(t, fieldsByName(entry)["key"].IsRequired())
+	ext := fldMap["[testdata.proto2.Plugin.plugin]"]
+	require.NotNil(t, ext)
+	assert.True(t, ext.IsExtension())
+	assert.False(t, fldMap["name"].IsExtension())
+	assert.Empty(t, res["testdata.proto2.Plugin"].GetMessage().FieldMeta()["[testdata.proto2.Plugin.plugin]"])
+}
+
+func TestWithoutExtensionsFrom(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"proto2/proto2.proto"},
+		IncludePaths: []string{"testdata"},
+	})
+	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	res, err := Load(ds, LoadOptions{})
+	require.NoError(t, err)
+	msg := res["testdata.proto2.Config"].GetMessage()
+	assert.Same(t, msg, msg.WithoutExtensionsFrom(map[string]bool{"other.proto": true}))
+	stripped := msg.WithoutExtensionsFrom(map[string]bool{"proto2/proto2.proto": true})
+	assert.Equal(t, msg.QualifiedName(), stripped.QualifiedName())
+	assert.Contains(t, fieldsByName(stripped), "name")
+	assert.NotContains(t, fieldsByName(stripped), "[testdata.proto2.owner]")
+	assert.NotContains(t, stripped.FieldMeta(), "[testdata.proto2.Plugin.plugin]")
+	assert.Contains(t, fieldsByName(msg), "[testdata.proto2.owner]")
+}
+
+func TestLocations(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"simple/simple.proto", "proto2/proto2.proto"},
+		IncludePaths: []string{"testdata"},
+	})
+	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	res, err := Load(ds, LoadOptions{})
+	require.NoError(t, err)
+	a := assert.New(t)
+	a.Equal("simple/simple.proto:5:1", res["testdata.simple.TopLevelEnum"].Location().String())
+	a.Equal("simple/simple.proto:11:1", res["testdata.simple.TopMessage"].Location().String())
+	a.Equal("simple/simple.proto:12:3", res["testdata.simple.TopMessage.InnerEnum"].Location().String())
+	inner := res["testdata.simple.TopMessage.InnerMessage2"].GetMessage()
+	a.Equal("simple/simple.proto:20:3", inner.Location().String())
+	a.Equal("simple/simple.proto:26:5", fieldsByName(inner)["simple_map"].Location().String())
+	config := fieldsByName(res["testdata.proto2.Config"].GetMessage())
+	a.Equal("proto2/proto2.proto:28:3", config["[testdata.proto2.owner]"].Location().String())
+	a.Equal("proto2/proto2.proto:34:5", config["[testdata.proto2.Plugin.plugin]"].Location().String())
+}
+
+func TestError(t *testing.T) {
+	err := &Error{
+		Location: Location{File: "a/b.proto", Line: 3, Column: 5},
+		Element:  "pkg.Message.field",
+		Err:      errors.New("bad rule"),
+	}
+	assert.Equal(t, "a/b.proto:3:5: pkg.Message.field: bad rule", err.Error())
+	assert.Equal(t, "a/b.proto", Location{File: "a/b.proto"}.String())
+	var target *Error
+	assert.True(t, errors.As(fmt.Errorf("wrapped: %w", err), &target))
+}
+
+func TestValidateOptionsExtensions(t *testing.T) {
+	req := testutil.Request(t, testutil.ProtocConfig{
+		Files:        []string{"extensions/extensions.proto"},
+		IncludePaths: []string{"testdata", ".."},
+	})
+	ds := &descriptorpb.FileDescriptorSet{File: req.GetProtoFile()}
+	tests := []struct {
+		name    string
+		opts    LoadOptions
+		skipped []string
+	}{
+		{name: "default", opts: LoadOptions{}},
+		{name: "exclude_extendee", opts: LoadOptions{ValidationsExclude: []string{"testdata.extensions.Config"}}, skipped: []string{"name"}},
+		{
+			name:    "exclude_declaring_message",
+			opts:    LoadOptions{ValidationsExclude: []string{"testdata.extensions.Plugin"}},
+			skipped: []string{"[testdata.extensions.Plugin.plugin_id]"},
+		},
+		{
+			name:    "include_declaring_message",
+			opts:    LoadOptions{ValidationsInclude: []string{"testdata.extensions.Plugin"}},
+			skipped: []string{"name", "[testdata.extensions.owner]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := Load(ds, test.opts)
+			require.NoError(t, err)
+			fldMap := fieldsByName(res["testdata.extensions.Config"].GetMessage())
+			for _, name := range []string{"name", "[testdata.extensions.Plugin.plugin_id]", "[testdata.extensions.owner]"} {
+				require.Contains(t, fldMap, name)
+				skipped := false
+				for _, s := range test.skipped {
+					skipped = skipped || s == name
+				}
+				assert.Equal(t, skipped, fldMap[name].ValidationRules() == nil, name)
+			}
+		})
+	}
+}
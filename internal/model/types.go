@@ -17,10 +17,12 @@
 package model
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/splunk/protobuf-jsonnet/internal/validate"
@@ -45,17 +47,23 @@ const (
 	ContainerTypeMap  ContainerType = "map"
 )
 
-var optionSkipValidateRules = false
-
-func SetSkipValidations(flag bool) {
-	optionSkipValidateRules = flag
-}
-
 // base is a message or an enum, possibly nested under another type.
 type base struct {
+	file    string   // the proto file in which it is defined
 	pkg     string   // the package in which it belongs
 	name    string   // type name
 	parents []string // list of local type names that are its parent, immediate last
+	loc     Location // the position of the definition in the proto file
+}
+
+// Location returns the position of the type in its proto file.
+func (b *base) Location() Location {
+	return b.loc
+}
+
+// File returns the name of the proto file in which the type is defined.
+func (b *base) File() string {
+	return b.file
 }
 
 // Name returns the base name of the type
@@ -92,19 +100,33 @@ func (b *base) QualifiedName() string {
 
 // Field is a field in a message.
 type Field struct {
-	f          *descriptorpb.FieldDescriptorProto
-	ft         FieldType
-	ct         ContainerType
-	typeName   string
-	oneOfGroup string
-	rules      *validate.FieldRules
-}
-
-// Name returns the canonical name for the field.
+	f           *descriptorpb.FieldDescriptorProto
+	ft          FieldType
+	ct          ContainerType
+	typeName    string
+	keyTypeName string
+	oneOfGroup  string
+	optional    bool
+	maskTarget  string
+	extension   string
+	rules       *validate.FieldRules
+	loc         Location
+}
+
+// Name returns the canonical name for the field. Extensions are named by their qualified name in brackets, as in
+// the proto3 JSON mapping.
 func (f *Field) Name() string {
+	if f.extension != "" {
+		return "[" + f.extension + "]"
+	}
 	return f.f.GetName()
 }
 
+// Location returns the position of the field in its proto file.
+func (f *Field) Location() Location {
+	return f.loc
+}
+
 // jsonName returns the JSON name for the field.
 func (f *Field) jsonName() string {
 	return f.f.GetJsonName()
@@ -113,7 +135,7 @@ func (f *Field) jsonName() string {
 // AllowedNames returns the set of names allowed to refer to this field.
 func (f *Field) AllowedNames() []string {
 	ret := []string{f.Name()}
-	if f.Name() != f.jsonName() {
+	if f.extension == "" && f.Name() != f.jsonName() {
 		ret = append(ret, f.jsonName())
 	}
 	return ret
@@ -124,6 +146,86 @@ func (f *Field) OneOfGroup() string {
 	return f.oneOfGroup
 }
 
+// IsExtension returns true if the field is an extension declared outside the message it belongs to.
+func (f *Field) IsExtension() bool {
+	return f.extension != ""
+}
+
+// IsOptional returns true if the field is declared with the proto3 optional keyword, giving it explicit presence.
+func (f *Field) IsOptional() bool {
+	return f.optional
+}
+
+// HasDefault returns true if the field has an explicit proto2 default value.
+func (f *Field) HasDefault() bool {
+	return f.f.DefaultValue != nil
+}
+
+// DefaultValue returns the proto2 default value of the field in the form used by the proto3 JSON mapping, or nil if
+// the field has no explicit default. 64-bit integers and special floating point values are strings and bytes are
+// base64 encoded.
+func (f *Field) DefaultValue() interface{} {
+	if !f.HasDefault() {
+		return nil
+	}
+	v := f.f.GetDefaultValue()
+	switch f.f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		return v
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return base64.StdEncoding.EncodeToString(unescapeBytes(v))
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return v == "true"
+	case descriptorpb.FieldDescriptorProto_TYPE_FLOAT, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE:
+		switch v {
+		case "inf":
+			return "Infinity"
+		case "-inf":
+			return "-Infinity"
+		case "nan":
+			return "NaN"
+		}
+		return json.Number(v)
+	case descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_SFIXED64, descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		descriptorpb.FieldDescriptorProto_TYPE_FIXED64:
+		return v
+	default:
+		return json.Number(v)
+	}
+}
+
+// unescapeBytes reverses the C-style escaping protoc uses for default values of bytes fields.
+func unescapeBytes(s string) []byte {
+	var ret []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			ret = append(ret, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			ret = append(ret, '\n')
+		case 'r':
+			ret = append(ret, '\r')
+		case 't':
+			ret = append(ret, '\t')
+		case '0', '1', '2', '3':
+			end := i + 1
+			for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(s[i:end], 8, 8)
+			ret = append(ret, byte(n))
+			i = end - 1
+		default:
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
 // FieldType returns the field type of the field.
 func (f *Field) FieldType() FieldType {
 	return f.ft
@@ -140,6 +242,17 @@ func (f *Field) TypeName() string {
 	return f.typeName
 }
 
+// KeyTypeName returns the primitive type name of map keys, or the empty string if the field is not a map.
+func (f *Field) KeyTypeName() string {
+	return f.keyTypeName
+}
+
+// MaskTarget returns the qualified name of the message that the paths of a FieldMask field refer to, or the empty
+// string if no target has been configured.
+func (f *Field) MaskTarget() string {
+	return f.maskTarget
+}
+
 // IsList returns true if the field is a list.
 func (f *Field) IsList() bool {
 	return f.ct == ContainerTypeList
@@ -161,21 +274,26 @@ func (f *Field) ValidationRules() *validate.FieldRules {
 	return f.rules
 }
 
-// IsRequired returns true if the field is required to be present.
+// IsRequired returns true if the field is required to be present, either by a proto2 required label or by
+// validation rules.
 func (f *Field) IsRequired() bool {
+	if f.f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED {
+		return true
+	}
 	if f.rules == nil {
 		return false
 	}
 	switch f.ContainerType() {
 	case ContainerTypeNone:
-		reqd := f.rules.Message != nil && f.rules.Message.GetRequired()
+		reqd := (f.rules.Message != nil && f.rules.Message.GetRequired()) || f.rules.GetTimestamp().GetRequired() ||
+			f.rules.GetDuration().GetRequired() || f.rules.GetAny().GetRequired()
 		if reqd {
 			// in languages where the type is determined by reflection it is possible to
 			// have a one of type set where the underlying message is nil. But in JSON, the inner type
 			// is actually determined by the presence of the field, so we cannot get into a situation
 			// where the field exists but is not-nil.
 			// Therefore, we honor the required validation flag only for non one-of types.
-			if f.f.OneofIndex == nil {
+			if f.oneOfGroup == "" {
 				return true
 			}
 		}
@@ -191,17 +309,47 @@ func (f *Field) IsRequired() bool {
 	return false
 }
 
-// Constraints returns field rules as a JSON string.
+// integerRules are the keys of rules for 64-bit integers, whose values may not be exactly representable as numbers in
+// generated code.
+var integerRules = map[string]bool{"Int64": true, "Uint64": true, "Sint64": true, "Fixed64": true, "Sfixed64": true}
+
+// Constraints returns field rules as a JSON string. Values of 64-bit integer rules are strings, as in the proto3 JSON
+// mapping, so that they can be compared exactly.
 func (f *Field) Constraints() map[string]interface{} {
 	if f.rules == nil || f.rules.Type == nil {
 		return nil
 	}
 	b, _ := json.Marshal(f.rules.Type)
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
 	var ret map[string]interface{}
-	_ = json.Unmarshal(b, &ret)
+	_ = d.Decode(&ret)
+	convertNumbers(ret, false)
 	return ret
 }
 
+// convertNumbers replaces the JSON numbers in the supplied map or slice with strings under 64-bit integer rules, or
+// when integer is set, and with floating point numbers otherwise.
+func convertNumbers(v interface{}, integer bool) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if integer {
+			return v.String()
+		}
+		n, _ := v.Float64()
+		return n
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = convertNumbers(x, integer || integerRules[k])
+		}
+	case []interface{}:
+		for i, x := range v {
+			v[i] = convertNumbers(x, integer)
+		}
+	}
+	return v
+}
+
 // Enum is a protobuf enum definition.
 type Enum struct {
 	base
@@ -253,10 +401,12 @@ func (e *Enum) NameForFirstValue() string {
 	return e.e.GetValue()[0].GetName()
 }
 
-func newEnum(pkg string, e *descriptorpb.EnumDescriptorProto, parent *Message) *Enum {
+func newEnum(file *descriptorpb.FileDescriptorProto, e *descriptorpb.EnumDescriptorProto, parent *Message, path []int32) *Enum {
 	b := base{
+		file: file.GetName(),
 		name: e.GetName(),
-		pkg:  pkg,
+		pkg:  file.GetPackage(),
+		loc:  locate(file, path),
 	}
 	if parent != nil {
 		b.parents = append(parent.parents[:], parent.name)
@@ -279,6 +429,7 @@ type OneOf struct {
 type Message struct {
 	base
 	m              *descriptorpb.DescriptorProto
+	disabled       bool // the validate.disabled option is set on the message
 	fields         []*Field
 	oneOfs         []*OneOf
 	nestedMessages []*Message
@@ -300,6 +451,24 @@ func (m Message) Fields() []*Field {
 	return m.fields
 }
 
+// WithoutExtensionsFrom returns the message without the extension fields declared in the supplied files, or the
+// message itself if it has no such fields. The message is not modified since other users may still need them.
+func (m *Message) WithoutExtensionsFrom(files map[string]bool) *Message {
+	var fields []*Field
+	for _, f := range m.fields {
+		if f.IsExtension() && files[f.loc.File] {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	if len(fields) == len(m.fields) {
+		return m
+	}
+	ret := *m
+	ret.fields = fields
+	return &ret
+}
+
 // NestedMessages returns the messages nested under this type.
 func (m *Message) NestedMessages() []*Message {
 	return m.nestedMessages
@@ -315,8 +484,11 @@ type FieldMeta struct {
 	Type          string                 `json:"type"`                    // the type name of the field as returned by
 	AllowedNames  []string               `json:"allowedNames"`            // the allowed names for the field
 	ContainerType ContainerType          `json:"containerType,omitempty"` // the container type
+	KeyType       string                 `json:"keyType,omitempty"`       // the type of map keys
 	Required      bool                   `json:"required,omitempty"`      // whether it is required
 	Constraints   map[string]interface{} `json:"constraints,omitempty"`   // type constraints associated with the field
+	MaskTarget    string                 `json:"maskTarget,omitempty"`    // the message referred to by FieldMask paths
+	Default       interface{}            `json:"default,omitempty"`       // the proto2 default value in JSON form
 }
 
 // FieldMeta returns a map of field metadata keyed by field name.
@@ -327,8 +499,11 @@ func (m *Message) FieldMeta() map[string]FieldMeta {
 			AllowedNames:  f.AllowedNames(),
 			Type:          f.TypeName(),
 			ContainerType: f.ContainerType(),
+			KeyType:       f.KeyTypeName(),
 			Required:      f.IsRequired(),
 			Constraints:   f.Constraints(),
+			MaskTarget:    f.MaskTarget(),
+			Default:       f.DefaultValue(),
 		}
 		ret[f.Name()] = meta
 	}
@@ -342,7 +517,8 @@ func (m *Message) OneOfs() []*OneOf {
 
 func extractFieldTypeAndName(f *descriptorpb.FieldDescriptorProto) (fType FieldType, name string) {
 	switch f.GetType() {
-	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		// groups are encoded differently on the wire but are nested messages in every other respect
 		return FieldTypeMessage, strings.TrimPrefix(f.GetTypeName(), ".")
 	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
 		return FieldTypeEnum, strings.TrimPrefix(f.GetTypeName(), ".")
@@ -352,11 +528,31 @@ func extractFieldTypeAndName(f *descriptorpb.FieldDescriptorProto) (fType FieldT
 	}
 }
 
-// newMessage creates a new message.
-func newMessage(pkg string, m *descriptorpb.DescriptorProto, parent *Message) *Message {
+// newField creates a field for a field or extension descriptor.
+func newField(f *descriptorpb.FieldDescriptorProto, rules *validate.FieldRules, loc Location) *Field {
+	fType, name := extractFieldTypeAndName(f)
+	ct := ContainerTypeNone
+	if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		ct = ContainerTypeList // note: maps are handled in a second pass after all types have been processed
+	}
+	return &Field{
+		f:        f,
+		ft:       fType,
+		ct:       ct,
+		typeName: name,
+		rules:    rules,
+		optional: f.GetProto3Optional(),
+		loc:      loc,
+	}
+}
+
+// newMessage creates a new message. The path is the source code info path of the message in its file.
+func (c *loader) newMessage(file *descriptorpb.FileDescriptorProto, m *descriptorpb.DescriptorProto, parent *Message, path []int32) *Message {
 	b := base{
+		file: file.GetName(),
 		name: m.GetName(),
-		pkg:  pkg,
+		pkg:  file.GetPackage(),
+		loc:  locate(file, path),
 	}
 	if parent != nil {
 		b.parents = append(parent.parents[:], parent.name)
@@ -368,71 +564,82 @@ func newMessage(pkg string, m *descriptorpb.DescriptorProto, parent *Message) *M
 	}
 
 	var err error
-	disableValidation := optionSkipValidateRules
+	disableValidation := c.opts.skipValidations(ret.QualifiedName())
 	if !disableValidation {
 		disableValidation, err = shouldDisableValidation(m.GetOptions())
 		if err != nil {
-			log.Printf("Error getting disable options, %v, continue", err)
+			c.addError(ret.Location(), ret.QualifiedName(), fmt.Errorf("read validate.disabled option: %w", err))
 		}
+		ret.disabled = disableValidation
 	}
 
-	for _, o := range m.GetOneofDecl() {
+	// protoc wraps each proto3 optional field in a synthetic one-of that is not surfaced as a group.
+	synthetic := map[int32]bool{}
+	for _, f := range m.GetField() {
+		if f.GetProto3Optional() {
+			synthetic[f.GetOneofIndex()] = true
+		}
+	}
+	oneOfsByIndex := map[int32]*OneOf{}
+	for i, o := range m.GetOneofDecl() {
+		if synthetic[int32(i)] {
+			continue
+		}
 		var reqd bool
 		if !disableValidation {
 			reqd, err = isOneOfRequired(o.GetOptions())
 			if err != nil {
-				log.Printf("Error getting OneOf options, %v, continue", err)
+				c.addError(locate(file, childPath(path, 8, int32(i))), ret.QualifiedName()+"."+o.GetName(),
+					fmt.Errorf("read validate.required option: %w", err))
 			}
 		}
-		ret.oneOfs = append(ret.oneOfs, &OneOf{Group: o.GetName(), Required: reqd})
+		oneOf := &OneOf{Group: o.GetName(), Required: reqd}
+		oneOfsByIndex[int32(i)] = oneOf
+		ret.oneOfs = append(ret.oneOfs, oneOf)
 	}
-	for _, f := range m.GetField() {
+	for i, f := range m.GetField() {
+		loc := locate(file, childPath(path, 2, int32(i)))
 		var rules *validate.FieldRules
 		if !disableValidation {
 			rules, err = getValidationRules(f.GetOptions())
 			if err != nil {
-				log.Printf("Error getting validation rules for field %s in message %s, %v, continue", f.GetName(), ret.QualifiedName(), err)
+				c.addError(loc, ret.QualifiedName()+"."+f.GetName(), fmt.Errorf("read validate.rules option: %w", err))
 			}
 		}
 		var oneOfGroup string
-		if f.OneofIndex != nil {
-			i := int(f.GetOneofIndex())
-			ret.oneOfs[i].Fields = append(ret.oneOfs[i].Fields, f.GetName())
-			oneOfGroup = ret.oneOfs[i].Group
+		if oneOf := oneOfsByIndex[f.GetOneofIndex()]; f.OneofIndex != nil && oneOf != nil {
+			oneOf.Fields = append(oneOf.Fields, f.GetName())
+			oneOfGroup = oneOf.Group
 		}
-		fType, name := extractFieldTypeAndName(f)
-		ct := ContainerTypeNone
-		if f.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
-			ct = ContainerTypeList // note: maps are handled in a second pass after all types have been processed
-		}
-
-		ret.fields = append(ret.fields, &Field{
-			f:          f,
-			ft:         fType,
-			ct:         ct,
-			typeName:   name,
-			rules:      rules,
-			oneOfGroup: oneOfGroup,
-		})
+		field := newField(f, rules, loc)
+		field.oneOfGroup = oneOfGroup
+		ret.fields = append(ret.fields, field)
+	}
+	for i, x := range m.GetExtension() {
+		c.addExtension(x, ret.QualifiedName(), locate(file, childPath(path, 6, int32(i))))
 	}
 	var nm []*Message
-	for _, t := range m.GetNestedType() {
-		nm = append(nm, newMessage(pkg, t, ret))
+	for i, t := range m.GetNestedType() {
+		nm = append(nm, c.newMessage(file, t, ret, childPath(path, 3, int32(i))))
 	}
 	ret.nestedMessages = nm
 
 	var ne []*Enum
-	for _, t := range m.GetEnumType() {
-		ne = append(ne, newEnum(pkg, t, ret))
+	for i, t := range m.GetEnumType() {
+		ne = append(ne, newEnum(file, t, ret, childPath(path, 4, int32(i))))
 	}
 	ret.nestedMessages = nm
 	ret.nestedEnums = ne
+	ret.sortFields()
+
+	return ret
+}
 
-	sort.Slice(ret.fields, func(i, j int) bool {
-		left := ret.fields[i]
-		right := ret.fields[j]
+// sortFields sorts the fields of the message by name.
+func (m *Message) sortFields() {
+	sort.Slice(m.fields, func(i, j int) bool {
+		left := m.fields[i]
+		right := m.fields[j]
 		return left.Name() < right.Name()
 	})
-
-	return ret
 }
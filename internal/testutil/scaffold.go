@@ -34,9 +34,10 @@ import (
 )
 
 type ProtocConfig struct {
-	Files        []string
-	IncludePaths []string
-	Parameter    string
+	Files           []string
+	FilesToGenerate []string // files to generate, defaults to all files
+	IncludePaths    []string
+	Parameter       string
 }
 
 type CodeGenerator interface {
@@ -60,7 +61,8 @@ func Request(t *testing.T, cfg ProtocConfig) *pluginpb.CodeGeneratorRequest {
 		require.NoError(t, err)
 		args = append(args, "-I", abs)
 	}
-	args = append(args, fmt.Sprintf("--descriptor_set_out=%s", tmpName))
+	// protoc passes source info to plugins, which is needed to locate errors
+	args = append(args, fmt.Sprintf("--descriptor_set_out=%s", tmpName), "--include_source_info")
 	args = append(args, cfg.Files...)
 	t.Log(executable, args)
 	cmd := exec.Command(executable, args...)
@@ -73,13 +75,18 @@ func Request(t *testing.T, cfg ProtocConfig) *pluginpb.CodeGeneratorRequest {
 	}
 	require.NoError(t, err)
 
+	filesToGenerate := cfg.FilesToGenerate
+	if filesToGenerate == nil {
+		filesToGenerate = cfg.Files
+	}
+
 	b, err := os.ReadFile(tmpName)
 	require.NoError(t, err)
 	var desc descriptorpb.FileDescriptorSet
 	err = proto.Unmarshal(b, &desc)
 	require.NoError(t, err)
 	return &pluginpb.CodeGeneratorRequest{
-		FileToGenerate: cfg.Files,
+		FileToGenerate: filesToGenerate,
 		Parameter:      proto.String(cfg.Parameter),
 		ProtoFile:      desc.GetFile(),
 		CompilerVersion: &pluginpb.Version{